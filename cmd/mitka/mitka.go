@@ -1,13 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 
 	"github.com/molchalin/mitkabot/internal/config"
+	"github.com/molchalin/mitkabot/internal/delivery"
 	"github.com/molchalin/mitkabot/internal/handler"
-	"github.com/molchalin/mitkabot/internal/poll"
 )
 
 func main() {
@@ -16,16 +19,16 @@ func main() {
 		log.Fatal(err)
 	}
 
-	p, err := poll.NewPoll(cfg)
+	d, err := handler.NewDispatcher(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	d := handler.NewDispatcher(p)
 	bot, err := tgbotapi.NewBotAPI(cfg.TgToken)
 	if err != nil {
 		log.Fatal(err)
 	}
+	out := delivery.NewQueue(bot)
+	go out.Run()
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
@@ -35,65 +38,152 @@ func main() {
 		log.Fatalf("error: %v", err)
 	}
 
-	type msgID struct {
-		chatID int64
-		ID     int
-		olo    string
-	}
-	msgs := make(map[string]msgID)
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
 
-	for update := range updates {
+	for {
+		var update tgbotapi.Update
+		var ok bool
+		select {
+		case now := <-tick.C:
+			d.Tick(now)
+			continue
+		case f := <-out.Done():
+			f()
+			continue
+		case update, ok = <-updates:
+			if !ok {
+				return
+			}
+		}
 		var forceNewMsg bool
-		var username string
+		var user string
 		if update.CallbackQuery == nil && update.Message == nil {
 			continue
 		}
 		var chatID int64
-		if update.CallbackQuery != nil {
-			username = update.CallbackQuery.From.UserName
-			chatID = update.CallbackQuery.Message.Chat.ID
-			d.Handler(username, update.CallbackQuery.Data)
+		if q := update.CallbackQuery; q != nil {
+			user = d.User(int64(q.From.ID), q.From.UserName)
+			chatID = q.Message.Chat.ID
+			pressed := handler.Message{ChatID: chatID, ID: q.Message.MessageID}
+			var err error
+			if m, ok := d.LastMessage(user); ok && m.ChatID == pressed.ChatID && m.ID == pressed.ID {
+				err = d.Handler(user, q.Data)
+			} else {
+				err = &handler.Error{Kind: handler.KindState, Err: fmt.Errorf("stale message %v", pressed.ID)}
+				if chatID != cfg.ChatID {
+					// The message is older than the last one the bot knows, or
+					// the bot forgot it. Its buttons may belong to another
+					// state, so it's rebuilt instead of being handled.
+					log.Printf("WARN: user=%v pressed stale message %v", user, pressed.ID)
+					if ok {
+						out.Delete(m.ChatID, m.ID, logError("cant delete message %v", m.ID))
+					}
+					pressed.Olo = m.Olo
+					d.SetMessage(user, pressed)
+				}
+			}
+			answer(out, q, err)
 		} else {
 			forceNewMsg = true
-			username = update.Message.From.UserName
+			user = d.User(int64(update.Message.From.ID), update.Message.From.UserName)
 			chatID = update.Message.Chat.ID
-			d.Handler(username, update.Message.Text)
+			err := d.Message(user, chatID, update.Message.Text)
+			var e *handler.Error
+			if errors.As(err, &e) && chatID != cfg.ChatID {
+				msg := tgbotapi.NewMessage(chatID, e.Text(update.Message.From.LanguageCode))
+				msg.ReplyToMessageID = update.Message.MessageID
+				out.Send(chatID, msg, logError("cant send error to %v", user))
+			}
 		}
 		if chatID == cfg.ChatID {
 			continue
 		}
-		var mark tgbotapi.InlineKeyboardMarkup
-		if bs := d.Buttons(username); len(bs) > 0 {
-			mark = tgbotapi.NewInlineKeyboardMarkup(bs...)
+		show(out, d, user, chatID, forceNewMsg)
+	}
+}
+
+// show puts the menu of the user in place of the last message: the message
+// is edited, or a new one is sent and the last one is deleted.
+func show(out *delivery.Queue, d *handler.Dispatcher, user string, chatID int64, forceNew bool) {
+	m, ok := d.LastMessage(user)
+	if !ok || forceNew {
+		send(out, d, user, chatID, m, ok)
+		return
+	}
+	text, mark := menu(d, user)
+	msg := tgbotapi.NewEditMessageText(m.ChatID, m.ID, m.Olo+" "+text)
+	msg.ReplyMarkup = &mark
+	msg.ParseMode = "Markdown"
+	msg.DisableWebPagePreview = true
+	out.Edit(m.ChatID, m.ID, msg, func(msgNew tgbotapi.Message, err error) {
+		switch class := delivery.Classify(err); class {
+		case delivery.ClassNone:
+			d.SetMessage(user, handler.Message{ChatID: msgNew.Chat.ID, ID: msgNew.MessageID, Olo: swapOlo(m.Olo)})
+		case delivery.ClassNotModified:
+		case delivery.ClassGone:
+			send(out, d, user, m.ChatID, m, false)
+		case delivery.ClassBlocked:
+			log.Printf("WARN: user=%v blocked the bot: %v", user, err)
+			d.Forget(user)
+		default:
+			log.Printf("WARN: cant edit menu of %v (%v): %v", user, class, err)
 		}
+	})
+}
 
-		if m, ok := msgs[username]; ok && !forceNewMsg {
-			msg := tgbotapi.NewEditMessageText(m.chatID, m.ID, m.olo+" "+d.Text(username))
-			msg.ReplyMarkup = &mark
-			msg.ParseMode = "Markdown"
-			msg.DisableWebPagePreview = true
-			msgNew, err := bot.Send(msg)
-			if err != nil {
-				log.Fatal(err)
-			}
-			msgs[username] = msgID{msgNew.Chat.ID, msgNew.MessageID, swapOlo(m.olo)}
-		} else {
-			m.olo = swapOlo(m.olo)
-			msg := tgbotapi.NewMessage(chatID, m.olo+" "+d.Text(username))
-			msg.DisableWebPagePreview = true
-			msg.ParseMode = "Markdown"
-			msg.ReplyMarkup = &mark
-			msgNew, err := bot.Send(msg)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if ok {
-				_, err := bot.DeleteMessage(tgbotapi.NewDeleteMessage(m.chatID, m.ID))
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
-			msgs[username] = msgID{msgNew.Chat.ID, msgNew.MessageID, swapOlo(m.olo)}
+// send sends the menu as a new message, the last message m is deleted if
+// the bot should delete it.
+func send(out *delivery.Queue, d *handler.Dispatcher, user string, chatID int64, m handler.Message, del bool) {
+	olo := swapOlo(m.Olo)
+	text, mark := menu(d, user)
+	msg := tgbotapi.NewMessage(chatID, olo+" "+text)
+	msg.DisableWebPagePreview = true
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = &mark
+	out.Send(chatID, msg, func(msgNew tgbotapi.Message, err error) {
+		switch class := delivery.Classify(err); class {
+		case delivery.ClassNone:
+		case delivery.ClassBlocked:
+			log.Printf("WARN: user=%v blocked the bot: %v", user, err)
+			d.Forget(user)
+			return
+		default:
+			log.Printf("WARN: cant send menu to %v (%v): %v", user, class, err)
+			return
+		}
+		if del {
+			out.Delete(m.ChatID, m.ID, logError("cant delete message %v of %v", m.ID, user))
+		}
+		d.SetMessage(user, handler.Message{ChatID: msgNew.Chat.ID, ID: msgNew.MessageID, Olo: swapOlo(olo)})
+	})
+}
+
+func menu(d *handler.Dispatcher, user string) (string, tgbotapi.InlineKeyboardMarkup) {
+	var mark tgbotapi.InlineKeyboardMarkup
+	if bs := d.Buttons(user); len(bs) > 0 {
+		mark = tgbotapi.NewInlineKeyboardMarkup(bs...)
+	}
+	return d.Text(user), mark
+}
+
+// answer stops the spinner of the pressed button, an error is shown as a
+// toast or an alert.
+func answer(out *delivery.Queue, q *tgbotapi.CallbackQuery, err error) {
+	cb := tgbotapi.NewCallback(q.ID, "")
+	var e *handler.Error
+	if errors.As(err, &e) {
+		cb.Text = e.Text(q.From.LanguageCode)
+		cb.ShowAlert = e.Alert()
+	}
+	out.Answer(cb, logError("cant answer callback of %v", q.From.ID))
+}
+
+// logError returns a Done logging a failed request.
+func logError(format string, args ...interface{}) delivery.Done {
+	return func(_ tgbotapi.Message, err error) {
+		if err != nil {
+			log.Printf("WARN: "+format+": %v", append(args, err)...)
 		}
 	}
 }
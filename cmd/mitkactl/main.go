@@ -2,10 +2,18 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/molchalin/mitkabot/internal/config"
 	"github.com/molchalin/mitkabot/internal/poll"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	toolName = flag.String("tool", "", "tool to run, the first argument is used if empty")
+	pollName = flag.String("poll", "", "poll to work with, poll_file of the config is used if empty")
 )
 
 func requirePoll(cfg *config.Config) {
@@ -26,19 +34,81 @@ func requireResultDB(cfg *config.Config) {
 	}
 }
 
+func requireReviewDB(cfg *config.Config) {
+	if cfg.ReviewDB == "" {
+		log.Fatalf("review_db required")
+	}
+}
+
+func requireReportResultDB(cfg *config.Config) {
+	if cfg.ReportResultDB == "" {
+		log.Fatalf("report_result_db required")
+	}
+}
+
+func requireUnsealed(p *poll.Poll) {
+	if p.Sealed() {
+		log.Fatalf("poll %v is sealed until closed", p.Name())
+	}
+}
+
+// createPhase returns a phase a created poll starts in, draft if --draft is set.
+func createPhase(tool string, args []string, phase string) string {
+	fs := flag.NewFlagSet(tool, flag.ExitOnError)
+	draft := fs.Bool("draft", false, "create the poll as a draft, its phase is changed later by the phase tool")
+	fs.Parse(args)
+	if *draft {
+		return poll.PhaseDraft
+	}
+	return phase
+}
+
 func main() {
 	cfg, err := config.Read()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	switch flag.Args()[0] {
+	if *pollName != "" {
+		cfg.PollFile = *pollName
+	}
+	if err := poll.LoadBindings(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	tool, args := *toolName, flag.Args()
+	if tool == "" {
+		if len(args) == 0 {
+			log.Fatalf("tool required")
+		}
+		tool, args = args[0], args[1:]
+	}
+
+	switch tool {
 	case "mk":
 		requirePoll(cfg)
 		requireBooksDB(cfg)
 		requireResultDB(cfg)
 
-		err := poll.CreatePollFromNotion(cfg)
+		err := poll.CreatePollFromNotion(cfg, createPhase("mk", args, poll.PhaseVoting))
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "mk_nom":
+		requirePoll(cfg)
+		requireBooksDB(cfg)
+		requireResultDB(cfg)
+
+		err := poll.CreatePollFromNotion(cfg, createPhase("mk_nom", args, poll.PhaseNomination))
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "mk_rep":
+		requirePoll(cfg)
+		requireReviewDB(cfg)
+		requireReportResultDB(cfg)
+
+		err := poll.CreateReportPollFromNotion(cfg, createPhase("mk_rep", args, poll.PhaseVoting))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -47,7 +117,192 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+	case "deadline":
+		requirePoll(cfg)
+		if len(args) != 1 {
+			log.Fatalf("usage: deadline 2006-01-02T15:04:05+03:00")
+		}
+		t, err := time.Parse(time.RFC3339, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, err := poll.NewPoll(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := p.SetDeadline("", t); err != nil {
+			log.Fatal(err)
+		}
+		if err := p.Save(); err != nil {
+			log.Fatal(err)
+		}
+	case "runoff":
+		requirePoll(cfg)
+
+		fs := flag.NewFlagSet("runoff", flag.ExitOnError)
+		top := fs.Int("top", 2, "number of best variants to get into runoff")
+		name := fs.String("name", "", "name of runoff poll, <poll_file>_2 by default")
+		fs.Parse(args)
+
+		p, err := poll.NewPoll(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *name == "" {
+			*name = p.RunoffName()
+		}
+		r, err := p.CreateRunoff(*name, *top)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range r.Variants {
+			fmt.Println(v.Text)
+		}
+	case "replay":
+		requirePoll(cfg)
+
+		fs := flag.NewFlagSet("replay", flag.ExitOnError)
+		until := fs.String("until", "", "moment to replay the journal until, e.g. 2006-01-02T15:04:05+03:00, now if empty")
+		fs.Parse(args)
+
+		t := time.Now()
+		if *until != "" {
+			t, err = time.Parse(time.RFC3339, *until)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		p, err := poll.NewPoll(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		requireUnsealed(p)
+		events, err := p.Events()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(events) == 0 {
+			log.Fatalf("poll %v has no journal", p.Name())
+		}
+		for _, e := range events {
+			if e.Time.After(t) {
+				break
+			}
+			fmt.Println(e)
+		}
+		r, err := p.Replay(t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("\nЭтап: %v\n", r.Phase)
+		for name, s := range r.State {
+			fmt.Printf("%v: %v\n", name, s.Votes)
+		}
+		fmt.Println()
+		for _, v := range r.Result(true) {
+			fmt.Printf("%v) %v\n", v.Place, v)
+		}
+	case "history":
+		names, err := poll.ListPolls(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range names {
+			p, err := poll.OpenPoll(cfg, name)
+			if err != nil {
+				log.Printf("WARN: %v: %v", name, err)
+				continue
+			}
+			fmt.Printf("%v (%v, %v, %v)", p.DisplayName(), p.Type, p.Method, poll.PhaseName(p.Phase))
+			if res := p.Result(false); len(res) > 0 && !p.Sealed() {
+				fmt.Printf(": %v", res[0])
+			}
+			if p.Next != "" {
+				fmt.Printf(", второй тур: %v", p.Next)
+			}
+			fmt.Println()
+		}
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		from := fs.String("from", "etc", "directory of yaml polls to import")
+		fs.Parse(args)
+
+		names, err := poll.Import(cfg, *from)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "migrate":
+		names, err := poll.ListPolls(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range names {
+			p, err := poll.OpenPoll(cfg, name)
+			if err != nil {
+				log.Fatalf("%v: %v", name, err)
+			}
+			if err := p.Save(); err != nil {
+				log.Fatalf("%v: %v", name, err)
+			}
+			fmt.Println(name)
+		}
+	case "members":
+		// members with user IDs they got from the bot, to replace
+		// notion_tg_map and members of the config.
+		data, err := yaml.Marshal(struct {
+			Members []config.Member `yaml:"members"`
+		}{cfg.Members})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(data))
+	case "phase":
+		requirePoll(cfg)
+
+		p, err := poll.NewPoll(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(args) == 0 {
+			fmt.Printf("%v (%v)\n", p.Phase, poll.PhaseName(p.Phase))
+			for _, phase := range []string{poll.PhaseDraft, poll.PhaseNomination, poll.PhaseVoting, poll.PhaseClosed, poll.PhasePublished} {
+				if t, ok := p.PhaseTimes[phase]; ok {
+					fmt.Printf("%v: %v\n", phase, t.In(p.Location()).Format(time.RFC3339))
+				}
+			}
+			return
+		}
+		if args[0] == poll.PhaseVoting && p.Phase != poll.PhaseClosed {
+			err = p.OpenVoting(cfg, "")
+		} else {
+			err = p.Move("", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := p.Save(); err != nil {
+			log.Fatal(err)
+		}
+	case "report":
+		requirePoll(cfg)
+
+		p, err := poll.NewPoll(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		requireUnsealed(p)
+		for _, v := range p.Result(true) {
+			fmt.Printf("%v) %v\n", v.Place, v)
+		}
+		if p.Method == poll.MethodRanked {
+			for i, r := range p.Runoff() {
+				fmt.Printf("\nРаунд %v:\n%v\n", i+1, r)
+			}
+		}
 	default:
-		log.Fatalf("unknown tool: %v", flag.Args()[0])
+		log.Fatalf("unknown tool: %v", tool)
 	}
 }
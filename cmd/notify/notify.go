@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/molchalin/mitkabot/internal/config"
+	"github.com/molchalin/mitkabot/internal/poll"
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/telegram"
 )
@@ -50,14 +51,48 @@ func main() {
 		if cnt%3 != 1 {
 			continue
 		}
-		err = notify.Send(
-			context.Background(),
-			"Проголосуй - или Дима сделает с тобой то же самое, что и со мной",
-			fmt.Sprintf("Осталось : %vh %vm", 23-hour, (60-min)%60),
-		)
-		if err != nil {
-			log.Fatal(err)
+		polls := cfg.ActivePolls()
+		for _, name := range polls {
+			p, subject, msg, ok := reminder(cfg, name, now)
+			if !ok {
+				continue
+			}
+			if len(polls) > 1 {
+				msg = fmt.Sprintf("%v. %v", p.DisplayName(), msg)
+			}
+			err = notify.Send(context.Background(), subject, msg)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 	}
 
 }
+
+// reminder returns a subject and a message to remind about the poll phase,
+// false if there is nothing to remind about.
+func reminder(cfg *config.Config, name string, now time.Time) (*poll.Poll, string, string, bool) {
+	p, err := poll.OpenPoll(cfg, name)
+	if err != nil {
+		log.Printf("WARN: cant read poll %v: %v", name, err)
+		return nil, "", "", false
+	}
+	switch p.Phase {
+	case poll.PhaseNomination:
+		return p, "Предложи книгу", "Идёт выдвижение книг, предложить можно в боте", true
+	case poll.PhaseVoting:
+		left, ok := timeLeft(p, now)
+		return p, "Проголосуй - или Дима сделает с тобой то же самое, что и со мной", fmt.Sprintf("Осталось : %v", poll.Countdown(left)), ok
+	}
+	return nil, "", "", false
+}
+
+// timeLeft returns time left until the poll deadline, false if it has passed.
+// Polls without a deadline are assumed to end at midnight.
+func timeLeft(p *poll.Poll, now time.Time) (time.Duration, bool) {
+	if left, ok := p.TimeLeft(now); ok {
+		return left, left > 0
+	}
+	hour, min, _ := now.Clock()
+	return time.Duration(23-hour)*time.Hour + time.Duration((60-min)%60)*time.Minute, true
+}
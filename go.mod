@@ -8,6 +8,7 @@ require (
 	github.com/jomei/notionapi v1.7.1
 	github.com/mehanizm/iuliia-go v1.0.2
 	github.com/nikoksr/notify v0.35.0
+	go.etcd.io/bbolt v1.3.6
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -17,13 +18,66 @@ type Config struct {
 	BookDB string `yaml:"book_db"`
 	// BookDB its an ID of Notion DB where poll results for this month are stored.
 	ResultDB string `yaml:"result_db"`
+	// ReviewDB its an ID of Notion DB where reviews for this month are stored.
+	ReviewDB string `yaml:"review_db"`
+	// ReportResultDB its an ID of Notion DB where report poll results for this month are stored.
+	ReportResultDB string `yaml:"report_result_db"`
 
-	// NotionTGMap stores notion name -> tg nickname mapping.
+	// Members of the club, see Member.
+	Members []Member `yaml:"members"`
+	// NotionTGMap stores notion name -> tg nickname mapping of old configs,
+	// its members are added to Members without IDs.
 	NotionTGMap map[string]string `yaml:"notion_tg_map"`
-	TGNotionMap map[string]string `yaml:"-"`
+	// MemberNotion maps member keys to notion names, NotionMember is the reverse.
+	MemberNotion map[string]string `yaml:"-"`
+	NotionMember map[string]string `yaml:"-"`
+	// Usernames maps member keys to tg nicknames, members may have none.
+	Usernames map[string]string `yaml:"-"`
 
-	PollFile string   `yaml:"poll_file"`
-	Admins   []string `yaml:"admins"`
+	PollFile string `yaml:"poll_file"`
+	// Admins are tg nicknames or user IDs.
+	Admins []string `yaml:"admins"`
+	// Polls the bot hosts at once, PollFile alone if empty.
+	Polls []string `yaml:"polls"`
+
+	// Storage keeps polls: yaml (default) or bolt.
+	Storage string `yaml:"storage"`
+	// StoragePath is a directory of yaml files, etc by default, or a bolt database file.
+	StoragePath string `yaml:"storage_path"`
+
+	// Method is a voting method of created polls: points, ranked, borda or approval.
+	Method string `yaml:"method"`
+	// TieBreak is a tie-break rule of created polls: none, voters, first, earliest, admin or lottery.
+	TieBreak string `yaml:"tie_break"`
+	// Timezone of poll deadlines, Europe/Moscow by default.
+	Timezone string `yaml:"timezone"`
+	// Secrecy of created polls: open, admins (default) or sealed.
+	Secrecy string `yaml:"secrecy"`
+	// Anonymous polls push totals of variants to Notion instead of ballots of members.
+	Anonymous bool `yaml:"anonymous"`
+	// Shuffle shows variants of created polls to every member in their own order.
+	Shuffle bool `yaml:"shuffle"`
+
+	// AttendanceDB its an ID of Notion DB where meetings and reading check-ins are stored.
+	AttendanceDB string `yaml:"attendance_db"`
+	// AttendanceFile is a yaml list of meetings, used if there is no AttendanceDB.
+	AttendanceFile string `yaml:"attendance_file"`
+	// ActivityDays is a period members must attend during to get the whole budget, 31 by default.
+	ActivityDays int `yaml:"activity_days"`
+
+	// SessionTTL is how long the bot remembers an unfinished action of a user, 24h by default.
+	SessionTTL time.Duration `yaml:"session_ttl"`
+}
+
+// ActivePolls returns polls the bot should host.
+func (c *Config) ActivePolls() []string {
+	if len(c.Polls) > 0 {
+		return c.Polls
+	}
+	if c.PollFile != "" {
+		return []string{c.PollFile}
+	}
+	return nil
 }
 
 var configFile = flag.String("config", "/etc/mitka.yml", "path to config")
@@ -40,9 +94,7 @@ func Read() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	cfg.TGNotionMap = make(map[string]string, len(cfg.NotionTGMap))
-	for notion, tg := range cfg.NotionTGMap {
-		cfg.TGNotionMap[tg] = notion
-	}
+	cfg.addLegacyMembers()
+	cfg.Index()
 	return cfg, nil
 }
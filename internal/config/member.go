@@ -0,0 +1,131 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Member of the club. Members are known by Telegram user IDs, so renaming
+// an account keeps the ballot. The username is used for display and
+// mentions only.
+type Member struct {
+	// ID is a Telegram user ID. Members without it are matched by Username
+	// once and get it when they first talk to the bot, see Bind.
+	ID       int64  `yaml:"id,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	// Notion is a name of the member in Notion.
+	Notion string `yaml:"notion"`
+}
+
+// Key identifies the member in polls and sessions: the user ID, or the
+// username until the ID is known.
+func (m Member) Key() string {
+	if m.ID != 0 {
+		return strconv.FormatInt(m.ID, 10)
+	}
+	return m.Username
+}
+
+// addLegacyMembers moves NotionTGMap to Members.
+func (c *Config) addLegacyMembers() {
+	known := make(map[string]bool, len(c.Members))
+	for _, m := range c.Members {
+		known[m.Notion] = true
+	}
+	var names []string
+	for notion := range c.NotionTGMap {
+		if !known[notion] {
+			names = append(names, notion)
+		}
+	}
+	sort.Strings(names)
+	for _, notion := range names {
+		c.Members = append(c.Members, Member{Username: c.NotionTGMap[notion], Notion: notion})
+	}
+}
+
+// Index builds the member maps from Members, Read does it for the config
+// file. Maps built before are refilled in place, open polls share them.
+func (c *Config) Index() {
+	c.MemberNotion = refill(c.MemberNotion, len(c.Members))
+	c.NotionMember = refill(c.NotionMember, len(c.Members))
+	c.Usernames = refill(c.Usernames, len(c.Members))
+	for _, m := range c.Members {
+		c.MemberNotion[m.Key()] = m.Notion
+		c.NotionMember[m.Notion] = m.Key()
+		if m.Username != "" {
+			c.Usernames[m.Key()] = m.Username
+		}
+	}
+}
+
+// refill empties the map, a new one is made if it is nil.
+func refill(m map[string]string, size int) map[string]string {
+	if m == nil {
+		return make(map[string]string, size)
+	}
+	for k := range m {
+		delete(m, k)
+	}
+	return m
+}
+
+// MemberKey returns the key of a member known by name: a key or a
+// username of a member with an ID, as keys were before IDs. Unknown names
+// are returned as they are.
+func (c *Config) MemberKey(name string) string {
+	return MemberKey(c.Usernames, name)
+}
+
+// MemberKey is Config.MemberKey for the usernames map.
+func MemberKey(usernames map[string]string, name string) string {
+	if _, ok := usernames[name]; ok {
+		return name
+	}
+	for key, username := range usernames {
+		if strings.EqualFold(username, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// renameAdmin follows the rename of an admin listed by username. Admins are
+// changed in place, open polls share them.
+func (c *Config) renameAdmin(old, username string) {
+	for i, adm := range c.Admins {
+		if old != "" && strings.EqualFold(adm, old) {
+			c.Admins[i] = username
+		}
+	}
+}
+
+// Bind returns the key of the Telegram user. A member without an ID
+// having the username gets the ID, legacy is the key the member had before
+// then, empty if the member had the ID already. Usernames of members are
+// updated as accounts are renamed, admins too. Unknown users get their ID
+// as the key.
+func (c *Config) Bind(id int64, username string) (key, legacy string) {
+	if id == 0 {
+		return username, ""
+	}
+	for i, m := range c.Members {
+		if m.ID == id {
+			if username != "" && username != m.Username {
+				c.Members[i].Username = username
+				c.renameAdmin(m.Username, username)
+				c.Index()
+			}
+			return c.Members[i].Key(), ""
+		}
+	}
+	for i, m := range c.Members {
+		if m.ID == 0 && username != "" && strings.EqualFold(m.Username, username) {
+			c.Members[i].ID = id
+			c.Index()
+			return c.Members[i].Key(), m.Username
+		}
+	}
+	return strconv.FormatInt(id, 10), ""
+}
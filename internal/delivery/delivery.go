@@ -0,0 +1,131 @@
+// Package delivery sends messages to Telegram and recovers from its errors,
+// so a single user can't break the bot for everyone.
+package delivery
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Class of a Telegram error, it decides how the error is recovered from.
+type Class int
+
+const (
+	// ClassNone is no error.
+	ClassNone Class = iota
+	// ClassOther is an error the bot can't recover from, the message is dropped.
+	ClassOther
+	// ClassNotModified is an edit making no change, it is skipped.
+	ClassNotModified
+	// ClassGone is a message deleted or too old to edit, it is sent again.
+	ClassGone
+	// ClassBlocked is a user who blocked the bot or left, their session is dropped.
+	ClassBlocked
+	// ClassFlood is a request over the rate limit, it is retried after the wait.
+	ClassFlood
+	// ClassNetwork is a failed request, it is retried with a backoff.
+	ClassNetwork
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassNone:
+		return "none"
+	case ClassOther:
+		return "other"
+	case ClassNotModified:
+		return "not_modified"
+	case ClassGone:
+		return "gone"
+	case ClassBlocked:
+		return "blocked"
+	case ClassFlood:
+		return "flood"
+	case ClassNetwork:
+		return "network"
+	}
+	return "unknown"
+}
+
+// descriptions of Telegram errors by their classes, matched as substrings.
+var descriptions = []struct {
+	text  string
+	class Class
+}{
+	{"message is not modified", ClassNotModified},
+	{"message to edit not found", ClassGone},
+	{"message to delete not found", ClassGone},
+	{"message can't be edited", ClassGone},
+	{"message can't be deleted", ClassGone},
+	{"bot was blocked by the user", ClassBlocked},
+	{"user is deactivated", ClassBlocked},
+	{"chat not found", ClassBlocked},
+	{"bot can't initiate conversation", ClassBlocked},
+	{"bot was kicked", ClassBlocked},
+	{"Too Many Requests", ClassFlood},
+}
+
+// Classify returns the class of an error of the Telegram API.
+func Classify(err error) Class {
+	if err == nil {
+		return ClassNone
+	}
+	var tgErr tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		if tgErr.RetryAfter > 0 {
+			return ClassFlood
+		}
+		for _, d := range descriptions {
+			if strings.Contains(tgErr.Message, d.text) {
+				return d.class
+			}
+		}
+		return ClassOther
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return ClassNetwork
+	}
+	return ClassOther
+}
+
+// RetryAfter returns how long Telegram asks to wait after a flood error.
+func RetryAfter(err error) time.Duration {
+	var tgErr tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second
+	}
+	return time.Second
+}
+
+// Sender sends requests to Telegram. Errors are returned to be retried by
+// Queue or recovered from by Classify.
+type Sender struct {
+	bot *tgbotapi.BotAPI
+}
+
+// Send sends the message or the edit.
+func (s *Sender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return s.bot.Send(c)
+}
+
+// Delete deletes the message, messages already gone are skipped.
+func (s *Sender) Delete(chatID int64, id int) error {
+	_, err := s.bot.DeleteMessage(tgbotapi.NewDeleteMessage(chatID, id))
+	if Classify(err) == ClassGone {
+		return nil
+	}
+	return err
+}
+
+// Answer answers the callback query.
+func (s *Sender) Answer(cb tgbotapi.CallbackConfig) error {
+	_, err := s.bot.AnswerCallbackQuery(cb)
+	return err
+}
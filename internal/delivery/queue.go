@@ -0,0 +1,219 @@
+package delivery
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Done is called with the result of a request on the goroutine reading
+// Queue.Done, the message is empty unless a message was sent or edited.
+type Done func(tgbotapi.Message, error)
+
+// job is a request waiting in the queue.
+type job struct {
+	chatID int64
+	// msgID is an edited message, edits of the same message are merged.
+	msgID  int
+	answer bool
+	do     func(s *Sender) (tgbotapi.Message, error)
+	done   Done
+
+	attempts  int
+	notBefore time.Time
+}
+
+// Queue sends requests to Telegram one by one from its own goroutine,
+// keeping to the rate limits: a request per PerChat to every chat and a
+// request per Global in total. Flood errors pause the chat for the time
+// Telegram asks, network errors are retried with a backoff. An edit of a
+// message still waiting replaces it, so pressing a button again and again
+// costs a single request. Callback answers go first and aren't limited.
+type Queue struct {
+	PerChat time.Duration
+	Global  time.Duration
+	// Retries is how many times a request is retried before giving up.
+	Retries int
+	// Backoff is the first wait after a network error, it doubles every retry.
+	Backoff time.Duration
+
+	s    *Sender
+	in   chan *job
+	done chan func()
+
+	pending []*job
+	results []func()
+	// chats holds the time every chat may get the next request.
+	chats  map[int64]time.Time
+	global time.Time
+}
+
+// NewQueue returns a queue of the bot within Telegram limits: a request a
+// second to a chat and 30 requests a second in total. Run starts it.
+func NewQueue(bot *tgbotapi.BotAPI) *Queue {
+	return &Queue{
+		PerChat: time.Second,
+		Global:  time.Second / 30,
+		Retries: 3,
+		Backoff: time.Second,
+		s:       &Sender{bot: bot},
+		in:      make(chan *job, 256),
+		done:    make(chan func()),
+		chats:   make(map[int64]time.Time),
+	}
+}
+
+// Done returns results to run on the goroutine owning the state they change.
+func (q *Queue) Done() <-chan func() {
+	return q.done
+}
+
+// Send sends a new message to the chat.
+func (q *Queue) Send(chatID int64, c tgbotapi.Chattable, done Done) {
+	q.in <- &job{chatID: chatID, do: func(s *Sender) (tgbotapi.Message, error) { return s.Send(c) }, done: done}
+}
+
+// Edit edits the message, it replaces an edit of the message still waiting.
+func (q *Queue) Edit(chatID int64, msgID int, c tgbotapi.Chattable, done Done) {
+	q.in <- &job{chatID: chatID, msgID: msgID, do: func(s *Sender) (tgbotapi.Message, error) { return s.Send(c) }, done: done}
+}
+
+// Delete deletes the message, messages already gone are skipped.
+func (q *Queue) Delete(chatID int64, msgID int, done Done) {
+	q.in <- &job{chatID: chatID, do: func(s *Sender) (tgbotapi.Message, error) { return tgbotapi.Message{}, s.Delete(chatID, msgID) }, done: done}
+}
+
+// Answer answers the callback query.
+func (q *Queue) Answer(cb tgbotapi.CallbackConfig, done Done) {
+	q.in <- &job{answer: true, do: func(s *Sender) (tgbotapi.Message, error) { return tgbotapi.Message{}, s.Answer(cb) }, done: done}
+}
+
+// Run sends requests until the queue is stopped, it never returns.
+func (q *Queue) Run() {
+	for {
+		// results are handed over between jobs too, a busy queue must not
+		// hold callbacks until it drains.
+		for len(q.results) > 0 {
+			select {
+			case q.done <- q.results[0]:
+				q.results = q.results[1:]
+				continue
+			default:
+			}
+			break
+		}
+		j, wait := q.next(time.Now())
+		if j != nil {
+			q.run(j)
+			continue
+		}
+		var results chan func()
+		var result func()
+		if len(q.results) > 0 {
+			results, result = q.done, q.results[0]
+		}
+		var tick <-chan time.Time
+		if wait > 0 {
+			tick = time.After(wait)
+		}
+		select {
+		case j := <-q.in:
+			q.add(j)
+		case results <- result:
+			q.results = q.results[1:]
+		case <-tick:
+		}
+	}
+}
+
+// add puts the job at the end of the queue, or in place of a waiting edit
+// of the same message.
+func (q *Queue) add(j *job) {
+	if j.msgID != 0 {
+		for _, p := range q.pending {
+			if p.chatID == j.chatID && p.msgID == j.msgID {
+				p.do, p.done = j.do, j.done
+				return
+			}
+		}
+	}
+	q.pending = append(q.pending, j)
+}
+
+// next returns the first job allowed to run now, or how long to wait for
+// one, zero if the queue is empty. Requests to a chat keep their order.
+func (q *Queue) next(now time.Time) (*job, time.Duration) {
+	var wait time.Duration
+	later := func(t time.Time) {
+		if d := t.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	for i, j := range q.pending {
+		if j.answer && !j.notBefore.After(now) {
+			return q.take(i), 0
+		}
+	}
+	blocked := make(map[int64]bool)
+	for i, j := range q.pending {
+		if j.answer {
+			if j.notBefore.After(now) {
+				later(j.notBefore)
+			}
+			continue
+		}
+		if blocked[j.chatID] {
+			continue
+		}
+		blocked[j.chatID] = true
+		ready := j.notBefore
+		if t := q.chats[j.chatID]; t.After(ready) {
+			ready = t
+		}
+		if q.global.After(ready) {
+			ready = q.global
+		}
+		if ready.After(now) {
+			later(ready)
+			continue
+		}
+		return q.take(i), 0
+	}
+	return nil, wait
+}
+
+func (q *Queue) take(i int) *job {
+	j := q.pending[i]
+	q.pending = append(q.pending[:i], q.pending[i+1:]...)
+	return j
+}
+
+// run sends the request, a failed one is put back to be retried.
+func (q *Queue) run(j *job) {
+	msg, err := j.do(q.s)
+	now := time.Now()
+	if !j.answer {
+		q.chats[j.chatID] = now.Add(q.PerChat)
+		q.global = now.Add(q.Global)
+	}
+	switch class := Classify(err); class {
+	case ClassFlood, ClassNetwork:
+		if j.attempts < q.Retries {
+			wait := RetryAfter(err)
+			if class == ClassNetwork {
+				wait = q.Backoff << j.attempts
+			} else if !j.answer {
+				q.chats[j.chatID] = now.Add(wait)
+			}
+			log.Printf("WARN: telegram %v error, retry in %v: %v", class, wait, err)
+			j.attempts++
+			j.notBefore = now.Add(wait)
+			q.pending = append([]*job{j}, q.pending...)
+			return
+		}
+	}
+	if j.done != nil {
+		q.results = append(q.results, func() { j.done(msg, err) })
+	}
+}
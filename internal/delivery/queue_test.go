@@ -0,0 +1,134 @@
+package delivery
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// testJob returns a job answering with a message of the id.
+func testJob(chatID int64, msgID, id int) *job {
+	return &job{chatID: chatID, msgID: msgID, do: func(*Sender) (tgbotapi.Message, error) {
+		return tgbotapi.Message{MessageID: id}, nil
+	}}
+}
+
+func jobID(j *job) int {
+	msg, _ := j.do(nil)
+	return msg.MessageID
+}
+
+// nextID returns the id of the job ready at now.
+func nextID(t *testing.T, q *Queue, now time.Time) int {
+	t.Helper()
+	j, wait := q.next(now)
+	if j == nil {
+		t.Fatalf("no job is ready, wait %v", wait)
+	}
+	return jobID(j)
+}
+
+func TestQueueMergesEdits(t *testing.T) {
+	q := NewQueue(nil)
+	q.add(testJob(1, 10, 1))
+	q.add(testJob(2, 0, 2))
+	q.add(testJob(1, 10, 3))
+	q.add(testJob(1, 11, 4))
+
+	if len(q.pending) != 3 {
+		t.Fatalf("pending = %v jobs, want 3", len(q.pending))
+	}
+	if got := jobID(q.pending[0]); got != 3 {
+		t.Errorf("merged edit = %v, want the last one 3", got)
+	}
+}
+
+func TestQueueChatOrder(t *testing.T) {
+	q := NewQueue(nil)
+	q.add(testJob(1, 0, 1))
+	q.add(testJob(1, 0, 2))
+	q.add(testJob(2, 0, 3))
+
+	now := time.Now()
+	if got := nextID(t, q, now); got != 1 {
+		t.Fatalf("first job = %v, want 1", got)
+	}
+	q.chats[1] = now.Add(q.PerChat)
+	q.global = now.Add(q.Global)
+
+	if j, wait := q.next(now); j != nil || wait != q.Global {
+		t.Errorf("next within the global limit = %v, %v, want a wait of %v", j, wait, q.Global)
+	}
+	if got := nextID(t, q, now.Add(q.Global)); got != 3 {
+		t.Errorf("job after the global limit = %v, want 3 of another chat", got)
+	}
+	q.global = now.Add(2 * q.Global)
+	if j, wait := q.next(now.Add(2 * q.Global)); j != nil || wait != q.PerChat-2*q.Global {
+		t.Errorf("next within the chat limit = %v, %v, want a wait of %v", j, wait, q.PerChat-2*q.Global)
+	}
+	if got := nextID(t, q, now.Add(q.PerChat)); got != 2 {
+		t.Errorf("job after the chat limit = %v, want 2", got)
+	}
+	if j, wait := q.next(now.Add(q.PerChat)); j != nil || wait != 0 {
+		t.Errorf("next of an empty queue = %v, %v", j, wait)
+	}
+}
+
+func TestQueueRetryAfter(t *testing.T) {
+	q := NewQueue(nil)
+	flood := tgbotapi.Error{Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}
+	calls := 0
+	var res error
+	j := &job{chatID: 1, do: func(*Sender) (tgbotapi.Message, error) {
+		calls++
+		if calls == 1 {
+			return tgbotapi.Message{}, flood
+		}
+		return tgbotapi.Message{}, nil
+	}, done: func(_ tgbotapi.Message, err error) { res = err }}
+	q.add(j)
+	q.add(testJob(1, 0, 2))
+
+	start := time.Now()
+	q.run(q.take(0))
+	if len(q.results) != 0 || len(q.pending) != 2 || q.pending[0] != j {
+		t.Fatalf("flood error is not retried first: results %v, pending %v", len(q.results), len(q.pending))
+	}
+	if j.notBefore.Before(start.Add(5 * time.Second)) {
+		t.Errorf("retry at %v, want after retry_after", j.notBefore.Sub(start))
+	}
+	later := start.Add(4 * time.Second)
+	if next, wait := q.next(later); next != nil || wait <= 0 {
+		t.Errorf("chat is not paused for retry_after: %v, %v", next, wait)
+	}
+	retry, _ := q.next(start.Add(6 * time.Second))
+	if retry != j {
+		t.Fatalf("job after retry_after = %v, want the failed one", retry)
+	}
+	q.run(retry)
+	if calls != 2 || len(q.results) != 1 {
+		t.Fatalf("calls = %v, results = %v, want 2 and 1", calls, len(q.results))
+	}
+	q.results[0]()
+	if res != nil {
+		t.Errorf("result of the retry = %v, want nil", res)
+	}
+}
+
+func TestQueueAnswersFirst(t *testing.T) {
+	q := NewQueue(nil)
+	now := time.Now()
+	q.global = now.Add(time.Hour)
+	q.add(testJob(1, 0, 1))
+	answer := testJob(0, 0, 2)
+	answer.answer = true
+	q.add(answer)
+
+	if got := nextID(t, q, now); got != 2 {
+		t.Errorf("first job = %v, want the answer", got)
+	}
+	if j, wait := q.next(now); j != nil || wait != time.Hour {
+		t.Errorf("next after the answer = %v, %v, want a wait of the global limit", j, wait)
+	}
+}
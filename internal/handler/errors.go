@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/molchalin/mitkabot/internal/poll"
+)
+
+// ErrorKind tells what went wrong for the user.
+type ErrorKind int
+
+const (
+	// KindInternal is a failure the user can't fix: storage, Notion and so on.
+	KindInternal ErrorKind = iota
+	// KindUnknownUser is a user who is not a member.
+	KindUnknownUser
+	// KindNoPoll is a command when there is no active poll.
+	KindNoPoll
+	// KindState is a button of another state, pressed on an old keyboard.
+	KindState
+	// KindForbidden is an action the user may not do now.
+	KindForbidden
+	// KindRule is a vote breaking a rule of the poll, see poll.RuleError.
+	KindRule
+	// KindInput is a message or an argument the bot can't read.
+	KindInput
+	// KindConflict is a poll changed by someone else, it's read again.
+	KindConflict
+)
+
+// Error is an error of a handler. It is shown to the user as a toast, or as
+// an alert to be dismissed if the user should read it.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Alert reports whether the error is shown as an alert.
+func (e *Error) Alert() bool {
+	return e.Kind == KindRule || e.Kind == KindInput || e.Kind == KindInternal
+}
+
+// Text returns the message for the user in the language of their Telegram
+// client, Russian if the bot doesn't speak it.
+func (e *Error) Text(lang string) string {
+	texts, ok := errorTexts[baseLang(lang)]
+	if !ok {
+		texts = errorTexts[defaultLang]
+	}
+	var re *poll.RuleError
+	if e.Kind == KindRule && errors.As(e.Err, &re) {
+		if t, ok := texts.rules[re.Rule]; ok {
+			if re.Limit > 0 {
+				return fmt.Sprintf(t, re.Limit)
+			}
+			return t
+		}
+	}
+	return texts.kinds[e.Kind]
+}
+
+func newError(kind ErrorKind, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// classify wraps err into an Error by what it is caused by.
+func classify(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	var re *poll.RuleError
+	switch {
+	case errors.As(err, &re):
+		return &Error{Kind: KindRule, Err: err}
+	case errors.Is(err, poll.ErrConflict):
+		return &Error{Kind: KindConflict, Err: err}
+	case errors.Is(err, poll.ErrForbidden):
+		return &Error{Kind: KindForbidden, Err: err}
+	case errors.Is(err, poll.ErrBadProposal), errors.Is(err, ErrParse):
+		return &Error{Kind: KindInput, Err: err}
+	}
+	return &Error{Kind: KindInternal, Err: err}
+}
+
+const defaultLang = "ru"
+
+func baseLang(lang string) string {
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	return strings.ToLower(lang)
+}
+
+type errorText struct {
+	kinds map[ErrorKind]string
+	// rules are formatted with the limit if it's set.
+	rules map[string]string
+}
+
+var errorTexts = map[string]errorText{
+	"ru": {
+		kinds: map[ErrorKind]string{
+			KindInternal:    "Что-то сломалось, попробуйте позже",
+			KindUnknownUser: "Вы не участник клуба",
+			KindNoPoll:      "Сейчас нет голосований",
+			KindState:       "Кнопка устарела, меню обновлено",
+			KindForbidden:   "Сейчас это сделать нельзя",
+			KindRule:        "Голос нарушает правила голосования",
+			KindInput:       "Не получилось разобрать, попробуйте ещё раз",
+			KindConflict:    "Голосование только что изменилось, попробуйте ещё раз",
+		},
+		rules: map[string]string{
+			poll.RuleMaxVariants:   "Можно голосовать не больше чем за %v книги",
+			poll.RuleMaxPerVariant: "Одной книге можно дать не больше %v баллов",
+			poll.RuleBudget:        "Всего можно распределить не больше %v баллов",
+			poll.RuleVetoed:        "На эту книгу вы наложили вето",
+			poll.RuleBallot:        "Такой бюллетень не подходит",
+		},
+	},
+	"en": {
+		kinds: map[ErrorKind]string{
+			KindInternal:    "Something went wrong, try again later",
+			KindUnknownUser: "You are not a club member",
+			KindNoPoll:      "There are no polls now",
+			KindState:       "The button is outdated, the menu is updated",
+			KindForbidden:   "You can't do it now",
+			KindRule:        "The vote breaks the poll rules",
+			KindInput:       "Couldn't read it, try again",
+			KindConflict:    "The poll has just changed, try again",
+		},
+		rules: map[string]string{
+			poll.RuleMaxVariants:   "You can vote for %v books at most",
+			poll.RuleMaxPerVariant: "A book can get %v points at most",
+			poll.RuleBudget:        "You can give %v points at most",
+			poll.RuleVetoed:        "You vetoed this book",
+			poll.RuleBallot:        "The ballot doesn't fit",
+		},
+	},
+}
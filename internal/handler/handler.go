@@ -1,21 +1,27 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/molchalin/mitkabot/internal/config"
 	"github.com/molchalin/mitkabot/internal/poll"
 )
 
 var globalAdmins = []string{"molchalin"}
 
-func IsGlobalAdmin(name string) bool {
+// isGlobalAdmin reports whether the member is a global admin, admins are
+// tg nicknames or user IDs.
+func (d *Dispatcher) isGlobalAdmin(name string) bool {
 	for _, adm := range globalAdmins {
-		if adm == name {
+		if adm == name || adm == d.cfg.Usernames[name] {
 			return true
 		}
 	}
@@ -29,7 +35,17 @@ const (
 	userStateVoteSelect
 	userStateVotePoints
 	userStateUnvoteSelect
-	userStateActivityCheck
+	userStateActivity
+	userStateTieSelect
+	userStateExtend
+	userStateRunoff
+	userStatePollSelect
+	userStateChange
+	userStateProxyMember
+	userStateProxySelect
+	userStateNominate
+	userStateVetoSelect
+	userStateUnvetoSelect
 )
 
 func (s userState) String() string {
@@ -42,15 +58,56 @@ func (s userState) String() string {
 		return "vote_points"
 	case userStateUnvoteSelect:
 		return "unvote_select"
+	case userStateActivity:
+		return "activity"
+	case userStateTieSelect:
+		return "tie_select"
+	case userStateExtend:
+		return "extend"
+	case userStateRunoff:
+		return "runoff"
+	case userStatePollSelect:
+		return "poll_select"
+	case userStateChange:
+		return "change"
+	case userStateProxyMember:
+		return "proxy_member"
+	case userStateProxySelect:
+		return "proxy_select"
+	case userStateNominate:
+		return "nominate"
+	case userStateVetoSelect:
+		return "veto_select"
+	case userStateUnvetoSelect:
+		return "unveto_select"
 	}
 	panic(fmt.Sprintf("unknown userState: %d", s))
 }
 
+// session is a key of per-user state, every poll has its own.
+type session struct {
+	poll string
+	user string
+}
+
 type Dispatcher struct {
-	p      *poll.Poll
-	m      map[string]Handler
-	state  map[string]userState
-	choice map[string]string
+	cfg *config.Config
+	// polls holds every loaded poll, the ones users can see are listed in active.
+	polls   map[string]*poll.Poll
+	active  []string
+	current map[string]string
+	m       map[string]Handler
+	state   map[session]userState
+	choice  map[session]string
+	// as holds members users vote for by proxy.
+	as map[session]string
+
+	// store keeps sessions across restarts, see saveSession.
+	store poll.Storage
+	msgs  map[string]Message
+	// seen is the last time users pressed or wrote anything, their FSM
+	// states are dropped after Config.SessionTTL.
+	seen map[string]time.Time
 }
 
 type Handler struct {
@@ -59,46 +116,110 @@ type Handler struct {
 	Argc     uint
 	State    userState
 	AnyState bool
+	// NoPoll handlers work when there is no active poll.
+	NoPoll bool
+}
+
+func (d *Dispatcher) checkUser(name string) error {
+	if _, ok := d.cfg.MemberNotion[name]; !ok {
+		return newError(KindUnknownUser, "unknown user")
+	}
+	return nil
 }
 
 func (d *Dispatcher) exec(h Handler, uname string, args []string) error {
-	if err := d.p.CheckUser(uname); err != nil {
+	if err := d.checkUser(uname); err != nil {
 		return err
 	}
+	if !h.NoPoll && d.poll(uname) == nil {
+		return newError(KindNoPoll, "no active poll for cmd=%v", h.Path)
+	}
 	if uint(len(args)) != h.Argc {
-		return fmt.Errorf("bad argc for cmd=%v: got=%v, want=%v", h.Path, len(args), h.Argc)
+		return newError(KindState, "bad argc for cmd=%v: got=%v, want=%v", h.Path, len(args), h.Argc)
 	}
-	if !h.AnyState && d.state[uname] != h.State {
-		return fmt.Errorf("bad state for cmd=%v: got=%v, want=%v", h.Path, d.state[uname], h.State)
+	if !h.AnyState && d.state[d.key(uname)] != h.State {
+		return newError(KindState, "bad state for cmd=%v: got=%v, want=%v", h.Path, d.state[d.key(uname)], h.State)
 	}
 	return h.F(uname, args)
 }
 
-func (d *Dispatcher) Handler(uname string, argStr string) {
+// Tick closes active polls once their deadlines have passed and expires
+// stale sessions.
+func (d *Dispatcher) Tick(now time.Time) {
+	d.expire(now)
+	for _, name := range d.active {
+		p := d.polls[name]
+		if !p.CloseExpired(now) {
+			continue
+		}
+		if err := p.Save(); err != nil {
+			log.Printf("WARN: cant save poll %v closed by deadline: %v", name, err)
+			if errors.Is(err, poll.ErrConflict) {
+				d.reload(name)
+			}
+		}
+	}
+}
+
+// Handler runs the command of a callback, the error is an *Error to show
+// to the user.
+func (d *Dispatcher) Handler(uname string, argStr string) error {
+	d.Tick(time.Now())
 	args := strings.Split(argStr, " ")
 	h, ok := d.m[args[0]]
 	if !ok {
 		log.Printf("WARN: unknown command %v %v", args[0], argStr)
-		return
+		return newError(KindState, "unknown command %v", args[0])
 	}
+	defer d.saveSession(uname)
+	d.touch(uname)
+	p := d.poll(uname)
 	err := d.exec(h, uname, args[1:])
-	if err != nil {
-		log.Printf("WARN: user=%v, argStr=%v err: %v", uname, argStr, err)
+	if err == nil {
+		return nil
 	}
+	log.Printf("WARN: user=%v, argStr=%v err: %v", uname, argStr, err)
+	if errors.Is(err, poll.ErrConflict) {
+		d.reload(p.Name())
+	}
+	return classify(err)
 }
 
-func NewDispatcher(p *poll.Poll) *Dispatcher {
+// NewDispatcher loads active polls of the config.
+func NewDispatcher(cfg *config.Config) (*Dispatcher, error) {
 	d := &Dispatcher{
-		p:      p,
-		state:  make(map[string]userState),
-		choice: make(map[string]string),
-		m:      make(map[string]Handler),
+		cfg:     cfg,
+		polls:   make(map[string]*poll.Poll),
+		current: make(map[string]string),
+		state:   make(map[session]userState),
+		choice:  make(map[session]string),
+		as:      make(map[session]string),
+		m:       make(map[string]Handler),
+		msgs:    make(map[string]Message),
+		seen:    make(map[string]time.Time),
+	}
+	store, err := poll.NewStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	d.store = store
+	if err := poll.LoadBindings(cfg); err != nil {
+		return nil, err
+	}
+	if err := d.loadSessions(time.Now()); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.ActivePolls() {
+		if err := d.activate(name); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, h := range []Handler{
 		{
-			Path: "update",
-			F:    d.update,
+			Path:   "update",
+			F:      d.update,
+			NoPoll: true,
 		},
 		{
 			Path: "vote",
@@ -138,17 +259,129 @@ func NewDispatcher(p *poll.Poll) *Dispatcher {
 			Path:     "menu",
 			F:        d.menu,
 			AnyState: true,
+			NoPoll:   true,
+		},
+		{
+			Path:   "polls",
+			F:      d.selectPoll,
+			NoPoll: true,
+		},
+		{
+			Path:   "poll_sel",
+			F:      d.pollSel,
+			Argc:   1,
+			State:  userStatePollSelect,
+			NoPoll: true,
+		},
+		{
+			Path:   "change",
+			F:      d.change,
+			NoPoll: true,
+		},
+		{
+			Path:   "change_sel",
+			F:      d.changeSel,
+			Argc:   1,
+			State:  userStateChange,
+			NoPoll: true,
+		},
+		{
+			Path: "activity",
+			F:    d.activity,
+		},
+		{
+			Path:  "activity_sel",
+			F:     d.activitySel,
+			Argc:  1,
+			State: userStateActivity,
+		},
+		{
+			Path: "extend",
+			F:    d.extend,
+		},
+		{
+			Path:  "extend_sel",
+			F:     d.extendSel,
+			Argc:  1,
+			State: userStateExtend,
+		},
+		{
+			Path: "runoff",
+			F:    d.runoff,
+		},
+		{
+			Path:  "runoff_sel",
+			F:     d.runoffSel,
+			Argc:  1,
+			State: userStateRunoff,
+		},
+		{
+			Path: "tie",
+			F:    d.tie,
+		},
+		{
+			Path:  "tie_sel",
+			F:     d.tieSel,
+			Argc:  1,
+			State: userStateTieSelect,
+		},
+		{
+			Path: "veto",
+			F:    d.veto,
+		},
+		{
+			Path:  "veto_sel",
+			F:     d.vetoSel,
+			Argc:  1,
+			State: userStateVetoSelect,
+		},
+		{
+			Path: "unveto",
+			F:    d.unveto,
+		},
+		{
+			Path:  "unveto_sel",
+			F:     d.unvetoSel,
+			Argc:  1,
+			State: userStateUnvetoSelect,
+		},
+		{
+			Path: "propose",
+			F:    d.propose,
+		},
+		{
+			Path: "nomination",
+			F:    d.openNomination,
+		},
+		{
+			Path: "open",
+			F:    d.openVoting,
+		},
+		{
+			Path: "vote_as",
+			F:    d.voteAs,
+			Argc: 1,
+		},
+		{
+			Path: "proxy",
+			F:    d.proxy,
+		},
+		{
+			Path:  "proxy_member",
+			F:     d.proxyMember,
+			Argc:  1,
+			State: userStateProxyMember,
 		},
 		{
-			Path:  "activity",
-			F:     d.activity,
+			Path:  "proxy_sel",
+			F:     d.proxySel,
 			Argc:  1,
-			State: userStateActivityCheck,
+			State: userStateProxySelect,
 		},
 	} {
 		d.m[h.Path] = h
 	}
-	return d
+	return d, nil
 }
 
 func checkArgc(args []string, cnt int) error {
@@ -158,64 +391,109 @@ func checkArgc(args []string, cnt int) error {
 var ErrParse = fmt.Errorf("cant parse arg")
 
 func (d *Dispatcher) vote(name string, args []string) error {
-	if d.p.CanVote(name) {
-		if d.p.NeedActivityCheck(name) {
-			d.state[name] = userStateActivityCheck
-		} else {
-			d.state[name] = userStateVoteSelect
-		}
+	p := d.poll(name)
+	if p.CanVote(d.voter(name)) {
+		d.state[d.key(name)] = userStateVoteSelect
 	}
 	return nil
 }
 
 func (d *Dispatcher) voteSel(name string, args []string) error {
-	if d.p.CanVote(name) {
-		d.state[name] = userStateVotePoints
-		d.choice[name] = args[0]
-	} else {
-		d.state[name] = userStateCmd
+	p := d.poll(name)
+	voter := d.voter(name)
+	if !p.CanVote(voter) {
+		d.state[d.key(name)] = userStateCmd
+		return nil
 	}
-	return nil
+	values := p.Values(voter)
+	if len(values) != 1 {
+		d.state[d.key(name)] = userStateVotePoints
+		d.choice[d.key(name)] = args[0]
+		return nil
+	}
+	err := p.VoteFor(name, voter, poll.Vote{Variant: args[0], Count: values[0]})
+	if err != nil {
+		return err
+	}
+	if !p.CanVote(voter) {
+		d.state[d.key(name)] = userStateCmd
+	}
+	return p.Save()
 }
 
 func (d *Dispatcher) unvote(name string, args []string) error {
-	if d.state[name] != userStateCmd {
-		return fmt.Errorf("bad state")
+	p := d.poll(name)
+	if d.state[d.key(name)] != userStateCmd {
+		return newError(KindState, "bad state")
 	}
-	if d.p.CanUnvote(name) {
-		d.state[name] = userStateUnvoteSelect
+	if p.CanUnvote(d.voter(name)) {
+		d.state[d.key(name)] = userStateUnvoteSelect
 	}
 	return nil
 }
 
 func (d *Dispatcher) unvoteSel(name string, args []string) error {
-	d.state[name] = userStateCmd
-	if !d.p.CanUnvote(name) {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	voter := d.voter(name)
+	if !p.CanUnvote(voter) {
 		return nil
 	}
-	err := d.p.DelVote(name, args[0])
+	err := p.DelVoteFor(name, voter, args[0])
 	if err != nil {
 		return err
 	}
-	return d.p.Save()
+	return p.Save()
 }
 
 func (d *Dispatcher) voteCnt(name string, args []string) error {
+	p := d.poll(name)
 	cnt, err := strconv.ParseUint(args[0], 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrParse, err)
 	}
-	d.state[name] = userStateCmd
-	if !d.p.CanVote(name) {
+	d.state[d.key(name)] = userStateCmd
+	voter := d.voter(name)
+	if !p.CanVote(voter) {
 		return nil
 	}
-	choice := d.choice[name]
-	delete(d.choice, name)
-	err = d.p.Vote(name, poll.Vote{choice, uint(cnt)})
+	choice := d.choice[d.key(name)]
+	delete(d.choice, d.key(name))
+	err = p.VoteFor(name, voter, poll.Vote{Variant: choice, Count: uint(cnt)})
 	if err != nil {
 		return err
 	}
-	return d.p.Save()
+	return p.Save()
+}
+
+// Message handles a text message from the chat, it is a proposal while the
+// user nominates a book and a command otherwise. Proposals are taken only
+// from the private chat the menu of the user is in, not from the club chat.
+func (d *Dispatcher) Message(uname string, chatID int64, text string) error {
+	m, ok := d.msgs[uname]
+	private := ok && m.ChatID == chatID && chatID != d.cfg.ChatID
+	if d.checkUser(uname) != nil || d.state[d.key(uname)] != userStateNominate || !private {
+		err := d.Handler(uname, text)
+		var e *Error
+		if errors.As(err, &e) && e.Kind == KindState {
+			// any text shows the menu, it's not an old button.
+			return nil
+		}
+		return err
+	}
+	d.Tick(time.Now())
+	defer d.saveSession(uname)
+	d.touch(uname)
+	p := d.poll(uname)
+	err := d.nominate(uname, text)
+	if err == nil {
+		return nil
+	}
+	log.Printf("WARN: user=%v, proposal=%q err: %v", uname, text, err)
+	if errors.Is(err, poll.ErrConflict) {
+		d.reload(p.Name())
+	}
+	return classify(err)
 }
 
 func (d *Dispatcher) update(name string, args []string) error {
@@ -223,86 +501,260 @@ func (d *Dispatcher) update(name string, args []string) error {
 }
 
 func (d *Dispatcher) stop(name string, args []string) error {
-	if !d.p.CanStop(name) {
-		return fmt.Errorf("you cant stop poll")
+	p := d.poll(name)
+	if err := p.Stop(name); err != nil {
+		return err
 	}
-	d.p.Closed = true
-	return d.p.Save()
+	return p.Save()
 }
 
 func (d *Dispatcher) resume(name string, args []string) error {
-	if !d.p.CanResume(name) {
-		return fmt.Errorf("you cant resume poll")
+	p := d.poll(name)
+	if err := p.Resume(name); err != nil {
+		return err
 	}
-	d.p.Closed = false
-	return d.p.Save()
+	return p.Save()
 }
 
 func (d *Dispatcher) menu(name string, args []string) error {
-	d.state[name] = userStateCmd
+	d.state[d.key(name)] = userStateCmd
 	return nil
 }
 
 func (d *Dispatcher) activity(name string, args []string) error {
-	if !d.p.NeedActivityCheck(name) {
-		return fmt.Errorf("no need for activity check")
-	}
-	s := d.p.State[name]
-	s.ActivityChecked = true
-	s.Activity = args[0] == "true"
-	d.p.State[name] = s
-	d.p.Save()
-	d.state[name] = userStateVoteSelect
+	p := d.poll(name)
+	if p.CanSetActivity(name) {
+		d.state[d.key(name)] = userStateActivity
+	}
+	return nil
+}
+
+// activitySel toggles activity of the member, the admin stays in the list.
+func (d *Dispatcher) activitySel(name string, args []string) error {
+	p := d.poll(name)
+	if !p.CanSetActivity(name) {
+		d.state[d.key(name)] = userStateCmd
+		return fmt.Errorf("you cant set activity: %w", poll.ErrForbidden)
+	}
+	if err := p.SetActivity(name, args[0], !p.Active(args[0])); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+// extensions are deadline extensions offered to admins, in hours.
+var extensions = []struct {
+	Text  string
+	Hours int
+}{
+	{"+1 час", 1},
+	{"+6 часов", 6},
+	{"+1 день", 24},
+	{"+3 дня", 72},
+}
+
+func (d *Dispatcher) extend(name string, args []string) error {
+	p := d.poll(name)
+	if p.CanExtend(name) {
+		d.state[d.key(name)] = userStateExtend
+	}
+	return nil
+}
+
+func (d *Dispatcher) extendSel(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	if !p.CanExtend(name) {
+		return fmt.Errorf("you cant extend poll: %w", poll.ErrForbidden)
+	}
+	hours, err := strconv.Atoi(args[0])
+	if err != nil || hours <= 0 {
+		return ErrParse
+	}
+	err = p.Extend(name, time.Now(), time.Duration(hours)*time.Hour)
+	if err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func (d *Dispatcher) runoff(name string, args []string) error {
+	p := d.poll(name)
+	if p.CanRunoff(name) {
+		d.state[d.key(name)] = userStateRunoff
+	}
+	return nil
+}
+
+// runoffSel starts the second round, it takes the place of the first one.
+func (d *Dispatcher) runoffSel(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	if !p.CanRunoff(name) {
+		return fmt.Errorf("you cant start runoff: %w", poll.ErrForbidden)
+	}
+	top, err := strconv.Atoi(args[0])
+	if err != nil {
+		return ErrParse
+	}
+	r, err := p.CreateRunoff(p.RunoffName(), top)
+	if err != nil {
+		return err
+	}
+	d.replace(p.Name(), r)
+	return nil
+}
+
+func (d *Dispatcher) tie(name string, args []string) error {
+	p := d.poll(name)
+	if p.CanBreakTie(name) {
+		d.state[d.key(name)] = userStateTieSelect
+	}
 	return nil
 }
 
+func (d *Dispatcher) tieSel(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	if !p.CanBreakTie(name) {
+		return fmt.Errorf("you cant break tie: %w", poll.ErrForbidden)
+	}
+	err := p.BreakTie(name, args[0])
+	if err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+// pollButtons returns menu buttons of the poll, voter is a member whose
+// ballot name casts.
+func pollButtons(p *poll.Poll, name, voter string) (res [][]tgbotapi.InlineKeyboardButton) {
+	if p.CanVote(voter) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Проголосовать", "vote")))
+	}
+	if p.CanUnvote(voter) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Удалить голос", "unvote")))
+	}
+	if p.CanVeto(voter) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Наложить вето", "veto")))
+	}
+	if p.CanUnveto(voter) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Снять вето", "unveto")))
+	}
+	if p.Phase == poll.PhaseVoting {
+		for _, member := range p.Principals(name) {
+			if member != voter {
+				res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf("Голосовать за %v", p.Mention(member)), fmt.Sprintf("vote_as %v", member))))
+			}
+		}
+		if voter != name {
+			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Голосовать за себя", fmt.Sprintf("vote_as %v", name))))
+		}
+	}
+	if p.CanSetProxy(name, name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Доверить голос", "proxy")))
+	}
+	if p.CanPropose(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Предложить книгу", "propose")))
+	}
+	if p.CanOpenNomination(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Открыть выдвижение", "nomination")))
+	}
+	if p.CanOpenVoting(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Открыть голосование", "open")))
+	}
+	if p.CanStop(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Остановить голосование", "stop")))
+	}
+	if p.CanResume(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Возобновить голосование", "resume")))
+	}
+	if p.CanExtend(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Продлить голосование", "extend")))
+	}
+	if p.CanBreakTie(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Выбрать победителя", "tie")))
+	}
+	if p.CanRunoff(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Второй тур", "runoff")))
+	}
+	if p.CanSetActivity(name) {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Активность участников", "activity")))
+	}
+	return res
+}
+
 func viewsToButtons(vs []poll.View, cmd string) (res [][]tgbotapi.InlineKeyboardButton) {
 	for _, v := range vs {
 		res = append(res,
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData(
-					fmt.Sprintf("%v. %v", v.Index, v.Text), fmt.Sprintf("%v %v", cmd, v.Short),
+					fmt.Sprintf("%v. %v", v.Number(), v.Text), fmt.Sprintf("%v %v", cmd, v.ID),
 				)))
 	}
 	return res
 }
 
 func (d *Dispatcher) Buttons(name string) (res [][]tgbotapi.InlineKeyboardButton) {
-	if d.p.CheckUser(name) != nil {
+	if d.checkUser(name) != nil {
 		return nil
 	}
-	switch d.state[name] {
+	p := d.poll(name)
+	switch d.state[d.key(name)] {
 	case userStateCmd:
 		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Обновить", "update")))
-		if d.p.CanVote(name) {
-			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Проголосовать", "vote")))
-		}
-		if d.p.CanUnvote(name) {
-			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Удалить голос", "unvote")))
+		if p != nil {
+			res = append(res, pollButtons(p, name, d.voter(name))...)
 		}
-		if d.p.CanStop(name) {
-			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Остановить голосование", "stop")))
+		if len(d.active) > 1 {
+			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Выбрать голосование", "polls")))
 		}
-		if d.p.CanResume(name) {
-			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Возобновить голосование", "resume")))
-		}
-		if IsGlobalAdmin(name) {
+		if d.isGlobalAdmin(name) {
 			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Сменить голосование", "change")))
 		}
 		return res
+	case userStatePollSelect:
+		res = d.selectButtons()
+	case userStateChange:
+		res = d.changeButtons()
 	case userStateVoteSelect:
-		res = viewsToButtons(d.p.GetView(name), "vote_sel")
+		res = viewsToButtons(p.GetViewToVote(d.voter(name)), "vote_sel")
 	case userStateUnvoteSelect:
-		res = viewsToButtons(d.p.GetViewNotEmpty(name), "unvote_sel")
+		res = viewsToButtons(p.GetViewNotEmpty(d.voter(name)), "unvote_sel")
+	case userStateVetoSelect:
+		res = viewsToButtons(p.GetViewToVeto(d.voter(name)), "veto_sel")
+	case userStateUnvetoSelect:
+		res = viewsToButtons(p.GetVetoes(d.voter(name)), "unveto_sel")
+	case userStateProxyMember:
+		for _, member := range p.Members() {
+			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(p.MemberName(member), fmt.Sprintf("proxy_member %v", member))))
+		}
+	case userStateProxySelect:
+		res = proxyButtons(p, d.choice[d.key(name)])
+	case userStateTieSelect:
+		res = viewsToButtons(p.TopTie(), "tie_sel")
+	case userStateRunoff:
+		for i := 2; i < len(p.Result(false)); i++ {
+			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Топ-%v", i), fmt.Sprintf("runoff_sel %v", i))))
+		}
+	case userStateExtend:
+		for _, e := range extensions {
+			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(e.Text, fmt.Sprintf("extend_sel %v", e.Hours))))
+		}
 	case userStateVotePoints:
-		for i := uint(1); i <= d.p.State[name].MaxPoints()-d.p.Points(name); i++ {
+		for _, i := range p.Values(d.voter(name)) {
 			res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(int(i)), fmt.Sprintf("vote_cnt %v", i))))
 		}
-	case userStateActivityCheck:
-		res = append(res,
-			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Да", "activity true")),
-			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Нет", "activity false")),
-		)
+	case userStateActivity:
+		for _, member := range p.Members() {
+			mark := "⬜"
+			if p.Active(member) {
+				mark = "✅"
+			}
+			res = append(res, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%v %v", mark, p.MemberName(member)), fmt.Sprintf("activity_sel %v", member))))
+		}
 	}
 	res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Вернуться в меню", "menu")))
 	return res
@@ -316,8 +768,12 @@ func mapString(arr []string, f func(s string) string) []string {
 	return res
 }
 
-func mention(name string) string {
-	return fmt.Sprintf("@%v", name)
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// mention is p.Mention escaped for Markdown messages, usernames often have
+// underscores.
+func mention(p *poll.Poll, member string) string {
+	return markdownEscaper.Replace(p.Mention(member))
 }
 
 func (d *Dispatcher) userNotFound(b *strings.Builder, name string) {
@@ -336,9 +792,11 @@ func convStr(arr interface{}) []string {
 	return res
 }
 
-func (d *Dispatcher) yourChoice(b *strings.Builder, name string) {
-	votes := d.p.GetViewNotEmpty(name)
-	if d.p.Closed {
+func yourChoice(b *strings.Builder, p *poll.Poll, name string) {
+	votes := p.GetViewNotEmpty(name)
+	if p.Phase == poll.PhasePublished {
+		b.WriteString("Голосование окончено, итоги опубликованы!\n")
+	} else if p.Closed() {
 		b.WriteString("Голосование окончено!\n")
 	} else if len(votes) == 0 {
 		b.WriteString("Вы еще не проголосовали\n")
@@ -347,50 +805,158 @@ func (d *Dispatcher) yourChoice(b *strings.Builder, name string) {
 	if len(votes) == 0 {
 		return
 	}
-	b.WriteString("Ваш выбор:\n")
-	b.WriteString(strings.Join(convStr(votes), "\n"))
+	if p.Ranked() {
+		b.WriteString("Ваш рейтинг:\n")
+		b.WriteString(strings.Join(places(votes), "\n"))
+	} else {
+		b.WriteString("Ваш выбор:\n")
+		b.WriteString(strings.Join(convStr(votes), "\n"))
+	}
 	b.WriteString("\n")
+	if !p.Closed() && !p.Complete(name) {
+		b.WriteString(fmt.Sprintf("Голос не засчитается, пока вы не распределите хотя бы %v баллов\n", p.Rules.MinSpend))
+	}
 	return
 }
 
-func (d *Dispatcher) progress(b *strings.Builder, name string) {
+func places(vs []poll.View) []string {
+	sorted := make([]poll.View, len(vs))
+	copy(sorted, vs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Count < sorted[j].Count
+	})
+	res := make([]string, len(sorted))
+	for i, v := range sorted {
+		res[i] = fmt.Sprintf("%v место: %v", v.Count, v.Text)
+	}
+	return res
+}
+
+func progress(b *strings.Builder, p *poll.Poll, name string) {
 	b.WriteString("\n")
-	noVote, total := d.p.Progress()
+	noVote, total := p.Progress()
 	b.WriteString(fmt.Sprintf("Проголосовало: %d/%d\n", total-len(noVote), total))
+	if left, ok := p.TimeLeft(time.Now()); ok && p.Phase == poll.PhaseVoting {
+		b.WriteString(fmt.Sprintf("До конца: %v (%v)\n", poll.Countdown(left), p.DeadlineString()))
+	}
+	if p.Phase == poll.PhaseVoting && !p.HasQuorum() {
+		quorum(b, p, name)
+	}
 
-	if !d.p.IsAdmin(name) && !d.p.Closed {
+	if !p.CanSeeResult(name) {
 		return
 	}
-	votes := d.p.Result(false)
+	votes := p.Result(false)
 	b.WriteString("\n")
 	b.WriteString("Результат:\n")
 	b.WriteString(strings.Join(convStr(votes), "\n"))
 	b.WriteString("\n")
+	if tie := p.TopTie(); len(tie) > 0 {
+		texts := make([]string, len(tie))
+		for i, v := range tie {
+			texts[i] = v.Text
+		}
+		b.WriteString(fmt.Sprintf("\nНичья! Первое место делят: %v\n", strings.Join(texts, ", ")))
+	}
+	if p.Method == poll.MethodRanked {
+		rounds(b, p)
+	}
+}
+
+func quorum(b *strings.Builder, p *poll.Poll, name string) {
+	need, missing := p.QuorumLeft()
+	if need > 0 {
+		b.WriteString(fmt.Sprintf("До кворума: ещё %v голосов\n", need))
+	}
+	if len(missing) > 0 {
+		b.WriteString(fmt.Sprintf("Должны проголосовать: %v\n", strings.Join(mapString(missing, func(m string) string { return mention(p, m) }), ", ")))
+	}
+	if !p.IsAdmin(name) {
+		return
+	}
+	if p.Quorum.Strict {
+		b.WriteString("Голосование не закроется, пока нет кворума\n")
+	} else {
+		b.WriteString("Кворума нет, результат может быть нечестным\n")
+	}
+}
+
+func rounds(b *strings.Builder, p *poll.Poll) {
+	for i, r := range p.Runoff() {
+		b.WriteString(fmt.Sprintf("\nРаунд %v:\n%v\n", i+1, r))
+	}
 }
 
 func (d *Dispatcher) Text(name string) string {
 	b := new(strings.Builder)
 
-	if d.p.CheckUser(name) != nil {
+	if d.checkUser(name) != nil {
 		d.userNotFound(b, name)
 		return b.String()
 	}
-	switch d.state[name] {
+	p := d.poll(name)
+	switch d.state[d.key(name)] {
 	case userStateCmd:
-		d.yourChoice(b, name)
-		d.progress(b, name)
+		if p == nil {
+			b.WriteString("Сейчас нет активных голосований\n")
+			break
+		}
+		if len(d.active) > 1 {
+			b.WriteString(fmt.Sprintf("*%v*\n", p.DisplayName()))
+		}
+		b.WriteString(fmt.Sprintf("Этап: %v\n", poll.PhaseName(p.Phase)))
+		switch p.Phase {
+		case poll.PhaseDraft:
+			b.WriteString("Голосование готовится\n")
+			return b.String()
+		case poll.PhaseNomination:
+			nomination(b, p, name)
+			return b.String()
+		}
+		voter := d.voter(name)
+		if voter != name {
+			b.WriteString(fmt.Sprintf("Вы голосуете за %v\n", mention(p, voter)))
+		}
+		yourChoice(b, p, voter)
+		vetoes(b, p, voter)
+		progress(b, p, name)
+	case userStatePollSelect:
+		b.WriteString("Выберите голосование")
+	case userStateChange:
+		b.WriteString("Отметьте голосования, которые видят участники")
 	case userStateVoteSelect, userStateUnvoteSelect:
 		t := "книгу"
-		if d.p.Type == poll.TypeReport {
+		if p.Type == poll.TypeReport {
 			t = "рецензию"
 		}
 		b.WriteString(fmt.Sprintf("Выберите %v", t))
+		if d.state[d.key(name)] == userStateVoteSelect && p.Ranked() {
+			b.WriteString(fmt.Sprintf(" на %v место", p.Values(d.voter(name))[0]))
+		}
 	case userStateVotePoints:
-		b.WriteString("Выберите количество баллов")
-	case userStateActivityCheck:
-		b.WriteString("В прошлом месяце вы читали книгу, учавствовали в обсуждении и т.д.(Новым участникам жать да) ?")
+		voter := d.voter(name)
+		b.WriteString(fmt.Sprintf("Выберите количество баллов (осталось %v из %v)", p.Budget(voter)-p.Points(voter), p.Budget(voter)))
+	case userStateVetoSelect:
+		b.WriteString(fmt.Sprintf("Выберите книгу, против которой вы голосуете. Вето отнимает у неё %v баллов", p.Rules.VetoPoints))
+	case userStateUnvetoSelect:
+		b.WriteString("Выберите книгу, с которой снять вето")
+	case userStateNominate:
+		b.WriteString("Пришлите книгу сообщением: название, автора и, если есть, ссылку — каждое с новой строки")
+	case userStateProxyMember:
+		b.WriteString("Выберите участника, которому нужно доверенное лицо")
+	case userStateProxySelect:
+		b.WriteString(fmt.Sprintf("Кто проголосует за %v?", mention(p, d.choice[d.key(name)])))
+	case userStateTieSelect:
+		b.WriteString("Выберите победителя")
+	case userStateRunoff:
+		b.WriteString("Сколько лучших вариантов выйдет во второй тур?")
+	case userStateExtend:
+		b.WriteString(fmt.Sprintf("Сейчас голосование до %v. На сколько продлить?", p.DeadlineString()))
+	case userStateActivity:
+		b.WriteString(fmt.Sprintf("Отметьте активных участников. Остальные распределяют %v баллов вместо %v", p.Rules.InactiveBudget, p.Rules.Budget))
 	default:
-		log.Fatalf("cant figure text for userState: %v", d.state[name])
+		log.Printf("WARN: cant figure text for userState: %v", d.state[d.key(name)])
+		b.WriteString("Вернитесь в меню")
 	}
 	return b.String()
 }
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"log"
+)
+
+// User returns the member key of a Telegram user. A member without a user
+// ID in the config gets it on first contact, the binding is stored and
+// everything keyed by the username is moved to the ID.
+func (d *Dispatcher) User(id int64, username string) string {
+	key, legacy := d.cfg.Bind(id, username)
+	if legacy == "" {
+		return key
+	}
+	log.Printf("INFO: member @%v got user id %v", legacy, id)
+	if err := d.store.SaveBinding(legacy, id); err != nil {
+		log.Printf("WARN: cant save binding of @%v: %v", legacy, err)
+	}
+	// polls are opened again to be rekeyed by the ID.
+	for name := range d.polls {
+		d.reload(name)
+	}
+	d.rekey(legacy, key)
+	return key
+}
+
+// rekey moves the sessions of the member from the old key to the new one.
+func (d *Dispatcher) rekey(old, key string) {
+	if old == key {
+		return
+	}
+	if cur, ok := d.current[old]; ok {
+		d.current[key] = cur
+		delete(d.current, old)
+	}
+	if m, ok := d.msgs[old]; ok {
+		d.msgs[key] = m
+		delete(d.msgs, old)
+	}
+	if t, ok := d.seen[old]; ok {
+		d.seen[key] = t
+		delete(d.seen, old)
+	}
+	for k, st := range d.state {
+		if k.user == old {
+			d.state[session{poll: k.poll, user: key}] = st
+			delete(d.state, k)
+		}
+	}
+	for k, c := range d.choice {
+		if k.user == old {
+			d.choice[session{poll: k.poll, user: key}] = c
+			delete(d.choice, k)
+		}
+	}
+	for k, a := range d.as {
+		if a == old {
+			a = key
+		}
+		if k.user == old {
+			delete(d.as, k)
+			k.user = key
+		}
+		d.as[k] = a
+	}
+	if err := d.store.SaveSession(old, nil); err != nil {
+		log.Printf("WARN: cant remove session of %v: %v", old, err)
+	}
+	d.saveSession(key)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/molchalin/mitkabot/internal/poll"
+)
+
+func (d *Dispatcher) propose(name string, args []string) error {
+	p := d.poll(name)
+	if p.CanPropose(name) {
+		d.state[d.key(name)] = userStateNominate
+	}
+	return nil
+}
+
+// nominate adds the book from a message, the user stays nominating until
+// the message is parsed.
+func (d *Dispatcher) nominate(name string, text string) error {
+	p := d.poll(name)
+	if !p.CanPropose(name) {
+		d.state[d.key(name)] = userStateCmd
+		return fmt.Errorf("you cant propose: %w", poll.ErrForbidden)
+	}
+	pr, err := poll.ParseProposal(text)
+	if err != nil {
+		return err
+	}
+	d.state[d.key(name)] = userStateCmd
+	if err := p.Propose(d.cfg, name, pr); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func (d *Dispatcher) openNomination(name string, args []string) error {
+	p := d.poll(name)
+	if err := p.OpenNomination(name); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func (d *Dispatcher) openVoting(name string, args []string) error {
+	p := d.poll(name)
+	if err := p.OpenVoting(d.cfg, name); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func nomination(b *strings.Builder, p *poll.Poll, name string) {
+	if len(p.Variants) > 0 {
+		b.WriteString("Уже в списке:\n")
+		for i, v := range p.Variants {
+			b.WriteString(fmt.Sprintf("%v. %v\n", i+1, markdownEscaper.Replace(v.Text)))
+		}
+	}
+	if len(p.Proposals) > 0 {
+		b.WriteString("\nПредложено в боте:\n")
+		for _, pr := range p.Proposals {
+			b.WriteString(fmt.Sprintf("%v (%v)\n", proposal(pr), mention(p, pr.Member)))
+		}
+	}
+	mine := len(p.ProposalsOf(name))
+	b.WriteString(fmt.Sprintf("\nВы предложили: %v из %v\n", mine, p.Rules.MaxProposals))
+}
+
+// proposal is Proposal.String for Markdown messages, the title and the author
+// are typed by members. A parenthesis would end the link early.
+func proposal(pr poll.Proposal) string {
+	title, author := markdownEscaper.Replace(pr.Title), markdownEscaper.Replace(pr.Author)
+	if pr.Link != "" {
+		return fmt.Sprintf("[%v](%v) — %v", title, strings.ReplaceAll(pr.Link, ")", "%29"), author)
+	}
+	return fmt.Sprintf("%v — %v", title, author)
+}
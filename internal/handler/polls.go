@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/molchalin/mitkabot/internal/poll"
+)
+
+// poll returns the poll the user looks at, the first active one by default.
+func (d *Dispatcher) poll(name string) *poll.Poll {
+	if cur, ok := d.current[name]; ok && d.isActive(cur) {
+		return d.polls[cur]
+	}
+	if len(d.active) == 0 {
+		return nil
+	}
+	return d.polls[d.active[0]]
+}
+
+func (d *Dispatcher) key(name string) session {
+	s := session{user: name}
+	if p := d.poll(name); p != nil {
+		s.poll = p.Name()
+	}
+	return s
+}
+
+func (d *Dispatcher) isActive(name string) bool {
+	for _, a := range d.active {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// activate loads the poll if needed and shows it to users.
+func (d *Dispatcher) activate(name string) error {
+	if d.isActive(name) {
+		return nil
+	}
+	if _, ok := d.polls[name]; !ok {
+		p, err := poll.OpenPoll(d.cfg, name)
+		if err != nil {
+			return err
+		}
+		d.polls[name] = p
+	}
+	d.active = append(d.active, name)
+	return nil
+}
+
+// reload reads the poll again after it was changed by someone else,
+// changes not saved yet are dropped.
+func (d *Dispatcher) reload(name string) {
+	p, err := poll.OpenPoll(d.cfg, name)
+	if err != nil {
+		log.Printf("WARN: cant reload poll %v: %v", name, err)
+		return
+	}
+	d.polls[name] = p
+}
+
+func (d *Dispatcher) deactivate(name string) {
+	n := d.active[:0]
+	for _, a := range d.active {
+		if a != name {
+			n = append(n, a)
+		}
+	}
+	d.active = n
+}
+
+// replace puts p in place of the old active poll, users looking at the old
+// poll are moved to the new one.
+func (d *Dispatcher) replace(old string, p *poll.Poll) {
+	d.polls[p.Name()] = p
+	for i, a := range d.active {
+		if a == old {
+			d.active[i] = p.Name()
+		}
+	}
+	for user, cur := range d.current {
+		if cur == old {
+			d.current[user] = p.Name()
+			d.saveSession(user)
+		}
+	}
+}
+
+func (d *Dispatcher) selectPoll(name string, args []string) error {
+	if len(d.active) > 1 {
+		d.state[d.key(name)] = userStatePollSelect
+	}
+	return nil
+}
+
+func (d *Dispatcher) pollSel(name string, args []string) error {
+	d.state[d.key(name)] = userStateCmd
+	if !d.isActive(args[0]) {
+		return newError(KindState, "poll %v is not active", args[0])
+	}
+	d.current[name] = args[0]
+	d.state[d.key(name)] = userStateCmd
+	return nil
+}
+
+func (d *Dispatcher) change(name string, args []string) error {
+	if d.isGlobalAdmin(name) {
+		d.state[d.key(name)] = userStateChange
+	}
+	return nil
+}
+
+// changeSel activates or deactivates the poll. The set of active polls is
+// kept until restart, the config one is used after it.
+func (d *Dispatcher) changeSel(name string, args []string) error {
+	if !d.isGlobalAdmin(name) {
+		d.state[d.key(name)] = userStateCmd
+		return fmt.Errorf("you cant change polls: %w", poll.ErrForbidden)
+	}
+	// the admin stays in the list until done, whatever poll they look at.
+	defer func() {
+		d.state[d.key(name)] = userStateChange
+	}()
+	if d.isActive(args[0]) {
+		d.deactivate(args[0])
+		return nil
+	}
+	return d.activate(args[0])
+}
+
+func (d *Dispatcher) selectButtons() (res [][]tgbotapi.InlineKeyboardButton) {
+	for _, name := range d.active {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(d.polls[name].DisplayName(), fmt.Sprintf("poll_sel %v", name))))
+	}
+	return res
+}
+
+func (d *Dispatcher) changeButtons() (res [][]tgbotapi.InlineKeyboardButton) {
+	names, err := poll.ListPolls(d.cfg)
+	if err != nil {
+		return nil
+	}
+	for _, name := range names {
+		mark := "⬜"
+		if d.isActive(name) {
+			mark = "✅"
+		}
+		res = append(res, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%v %v", mark, name), fmt.Sprintf("change_sel %v", name))))
+	}
+	return res
+}
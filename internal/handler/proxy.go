@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/molchalin/mitkabot/internal/poll"
+)
+
+// voter returns the member whose ballot the user casts, the user itself
+// unless they vote by proxy.
+func (d *Dispatcher) voter(name string) string {
+	member, ok := d.as[d.key(name)]
+	if !ok {
+		return name
+	}
+	if p := d.poll(name); p == nil || p.State[member].Proxy != name {
+		delete(d.as, d.key(name))
+		return name
+	}
+	return member
+}
+
+// voteAs switches the ballot the user casts, to their own one if args[0] is
+// the user.
+func (d *Dispatcher) voteAs(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	if args[0] == name {
+		delete(d.as, d.key(name))
+		return nil
+	}
+	if p.State[args[0]].Proxy != name {
+		return fmt.Errorf("%v is not a proxy of %v: %w", name, args[0], poll.ErrForbidden)
+	}
+	d.as[d.key(name)] = args[0]
+	return nil
+}
+
+// proxy lets an admin pick a member to set the proxy of, others set their own.
+func (d *Dispatcher) proxy(name string, args []string) error {
+	p := d.poll(name)
+	if !p.CanSetProxy(name, name) {
+		return nil
+	}
+	if p.IsAdmin(name) {
+		d.state[d.key(name)] = userStateProxyMember
+		return nil
+	}
+	d.choice[d.key(name)] = name
+	d.state[d.key(name)] = userStateProxySelect
+	return nil
+}
+
+func (d *Dispatcher) proxyMember(name string, args []string) error {
+	p := d.poll(name)
+	if !p.CanSetProxy(name, args[0]) {
+		d.state[d.key(name)] = userStateCmd
+		return fmt.Errorf("you cant set proxy of %v: %w", args[0], poll.ErrForbidden)
+	}
+	d.choice[d.key(name)] = args[0]
+	d.state[d.key(name)] = userStateProxySelect
+	return nil
+}
+
+// proxySel sets the proxy of the chosen member, "-" revokes it.
+func (d *Dispatcher) proxySel(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	member := d.choice[d.key(name)]
+	delete(d.choice, d.key(name))
+	proxy := args[0]
+	if proxy == "-" {
+		proxy = ""
+	}
+	if err := p.SetProxy(name, member, proxy); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func proxyButtons(p *poll.Poll, member string) (res [][]tgbotapi.InlineKeyboardButton) {
+	current := p.State[member].Proxy
+	for _, m := range p.Members() {
+		if m == member {
+			continue
+		}
+		text := p.MemberName(m)
+		if m == current {
+			text = "✅ " + text
+		}
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(text, fmt.Sprintf("proxy_sel %v", m))))
+	}
+	if current != "" {
+		res = append(res, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Отозвать доверенность", "proxy_sel -")))
+	}
+	return res
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// DefaultSessionTTL is used if the config has no session_ttl.
+const DefaultSessionTTL = 24 * time.Hour
+
+// Message is the last bot message of a user, its keyboard is the live one.
+type Message struct {
+	ChatID int64 `json:"chat_id"`
+	ID     int   `json:"id"`
+	// Olo is a mark the next text starts with, it alternates so an edited
+	// message always differs from the previous one.
+	Olo string `json:"olo"`
+}
+
+// storedSession is what the dispatcher keeps about a user across restarts.
+type storedSession struct {
+	Current string                 `json:"current,omitempty"`
+	Polls   map[string]pollSession `json:"polls,omitempty"`
+	Message *Message               `json:"message,omitempty"`
+	Seen    time.Time              `json:"seen"`
+}
+
+// pollSession is the state of a user in a poll. States are stored by name,
+// so they survive changes of userState values.
+type pollSession struct {
+	State  string `json:"state,omitempty"`
+	Choice string `json:"choice,omitempty"`
+	As     string `json:"as,omitempty"`
+}
+
+func parseUserState(str string) (userState, bool) {
+	for s := userStateCmd; s <= userStateUnvetoSelect; s++ {
+		if s.String() == str {
+			return s, true
+		}
+	}
+	return userStateCmd, false
+}
+
+func (d *Dispatcher) sessionTTL() time.Duration {
+	if d.cfg.SessionTTL > 0 {
+		return d.cfg.SessionTTL
+	}
+	return DefaultSessionTTL
+}
+
+// LastMessage returns the last bot message of the user.
+func (d *Dispatcher) LastMessage(name string) (Message, bool) {
+	m, ok := d.msgs[name]
+	return m, ok
+}
+
+// SetMessage remembers the last bot message of the user.
+func (d *Dispatcher) SetMessage(name string, m Message) {
+	d.msgs[name] = m
+	d.saveSession(name)
+}
+
+// Forget drops the session and the last message of the user, who can't be
+// reached: the bot was blocked or the account was deleted. The user starts
+// over if they come back.
+func (d *Dispatcher) Forget(name string) {
+	d.resetSession(name)
+	delete(d.msgs, name)
+	delete(d.seen, name)
+	d.saveSession(name)
+}
+
+// touch marks the user active now.
+func (d *Dispatcher) touch(name string) {
+	d.seen[name] = time.Now()
+}
+
+// resetSession drops FSM states, choices and proxies of the user in every
+// poll. The current poll and the message are kept.
+func (d *Dispatcher) resetSession(name string) {
+	for k := range d.state {
+		if k.user == name {
+			delete(d.state, k)
+		}
+	}
+	for _, m := range []map[session]string{d.choice, d.as} {
+		for k := range m {
+			if k.user == name {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// expire drops FSM states of users who have been away longer than the TTL.
+func (d *Dispatcher) expire(now time.Time) {
+	for name, seen := range d.seen {
+		if now.Sub(seen) < d.sessionTTL() {
+			continue
+		}
+		d.resetSession(name)
+		delete(d.seen, name)
+		d.saveSession(name)
+	}
+}
+
+// saveSession writes the session of the user to the storage.
+func (d *Dispatcher) saveSession(name string) {
+	s := storedSession{
+		Current: d.current[name],
+		Polls:   make(map[string]pollSession),
+		Seen:    d.seen[name],
+	}
+	if m, ok := d.msgs[name]; ok {
+		s.Message = &m
+	}
+	for k, st := range d.state {
+		if k.user == name && st != userStateCmd {
+			ps := s.Polls[k.poll]
+			ps.State = st.String()
+			s.Polls[k.poll] = ps
+		}
+	}
+	for k, c := range d.choice {
+		if k.user == name {
+			ps := s.Polls[k.poll]
+			ps.Choice = c
+			s.Polls[k.poll] = ps
+		}
+	}
+	for k, a := range d.as {
+		if k.user == name {
+			ps := s.Polls[k.poll]
+			ps.As = a
+			s.Polls[k.poll] = ps
+		}
+	}
+	var data []byte
+	if s.Current != "" || len(s.Polls) > 0 || s.Message != nil {
+		var err error
+		data, err = json.Marshal(s)
+		if err != nil {
+			log.Printf("WARN: cant encode session of %v: %v", name, err)
+			return
+		}
+	}
+	if err := d.store.SaveSession(name, data); err != nil {
+		log.Printf("WARN: cant save session of %v: %v", name, err)
+	}
+}
+
+// loadSessions restores sessions saved before restart, FSM states of
+// expired ones are dropped.
+func (d *Dispatcher) loadSessions(now time.Time) error {
+	sessions, err := d.store.Sessions()
+	if err != nil {
+		return err
+	}
+	var legacy []string
+	for name, data := range sessions {
+		if key := d.cfg.MemberKey(name); key != name {
+			legacy = append(legacy, name)
+			name = key
+		}
+		var s storedSession
+		if err := json.Unmarshal(data, &s); err != nil {
+			log.Printf("WARN: cant decode session of %v: %v", name, err)
+			continue
+		}
+		if s.Current != "" {
+			d.current[name] = s.Current
+		}
+		if s.Message != nil {
+			d.msgs[name] = *s.Message
+		}
+		if now.Sub(s.Seen) >= d.sessionTTL() {
+			continue
+		}
+		d.seen[name] = s.Seen
+		for pollName, ps := range s.Polls {
+			k := session{poll: pollName, user: name}
+			if st, ok := parseUserState(ps.State); ok && st != userStateCmd {
+				d.state[k] = st
+			}
+			if ps.Choice != "" {
+				d.choice[k] = ps.Choice
+			}
+			if ps.As != "" {
+				d.as[k] = d.cfg.MemberKey(ps.As)
+			}
+		}
+	}
+	// sessions saved before user IDs are moved to member keys.
+	for _, name := range legacy {
+		if err := d.store.SaveSession(name, nil); err != nil {
+			return err
+		}
+		d.saveSession(d.cfg.MemberKey(name))
+	}
+	return nil
+}
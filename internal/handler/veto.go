@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/molchalin/mitkabot/internal/poll"
+)
+
+func (d *Dispatcher) veto(name string, args []string) error {
+	p := d.poll(name)
+	if p.CanVeto(d.voter(name)) {
+		d.state[d.key(name)] = userStateVetoSelect
+	}
+	return nil
+}
+
+func (d *Dispatcher) vetoSel(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	if err := p.VetoFor(name, d.voter(name), args[0]); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func (d *Dispatcher) unveto(name string, args []string) error {
+	p := d.poll(name)
+	if p.CanUnveto(d.voter(name)) {
+		d.state[d.key(name)] = userStateUnvetoSelect
+	}
+	return nil
+}
+
+func (d *Dispatcher) unvetoSel(name string, args []string) error {
+	p := d.poll(name)
+	d.state[d.key(name)] = userStateCmd
+	voter := d.voter(name)
+	if !p.CanUnveto(voter) {
+		return nil
+	}
+	if err := p.UnvetoFor(name, voter, args[0]); err != nil {
+		return err
+	}
+	return p.Save()
+}
+
+func vetoes(b *strings.Builder, p *poll.Poll, name string) {
+	vs := p.GetVetoes(name)
+	if len(vs) == 0 {
+		return
+	}
+	b.WriteString("Ваше вето:\n")
+	b.WriteString(strings.Join(convStr(vs), "\n"))
+	b.WriteString("\n")
+}
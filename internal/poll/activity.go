@@ -0,0 +1,193 @@
+package poll
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jomei/notionapi"
+	"github.com/molchalin/mitkabot/internal/config"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultActivityDays is a period attendance is looked through if the config has none.
+const DefaultActivityDays = 31
+
+// Meeting is a record of a local attendance file: a meeting or a reading
+// check-in and tg nicknames or user IDs of members who were there.
+type Meeting struct {
+	Date    string   `yaml:"date"`
+	Members []string `yaml:"members"`
+}
+
+// ReadAttendance returns members who attended since the moment. It reports
+// false if the config has no attendance source.
+func ReadAttendance(cfg *config.Config, since time.Time) (map[string]bool, bool, error) {
+	switch {
+	case cfg.AttendanceDB != "":
+		res, err := attendanceFromNotion(cfg, since)
+		return res, true, err
+	case cfg.AttendanceFile != "":
+		res, err := attendanceFromFile(cfg, since)
+		return res, true, err
+	}
+	return nil, false, nil
+}
+
+// attendanceFromNotion reads pages of the attendance DB with a "Дата" date
+// and "Участники" people properties.
+func attendanceFromNotion(cfg *config.Config, since time.Time) (map[string]bool, error) {
+	cl := notionapi.NewClient(notionapi.Token(cfg.NotionToken))
+	from := notionapi.Date(since)
+	req := &notionapi.DatabaseQueryRequest{
+		PropertyFilter: &notionapi.PropertyFilter{
+			Property: "Дата",
+			Date:     &notionapi.DateFilterCondition{OnOrAfter: &from},
+		},
+	}
+	res := make(map[string]bool)
+	for {
+		v, err := cl.Database.Query(context.Background(), notionapi.DatabaseID(cfg.AttendanceDB), req)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range v.Results {
+			a, ok := page.Properties["Участники"].(*notionapi.PeopleProperty)
+			if !ok {
+				return nil, fmt.Errorf("members cast error")
+			}
+			for _, u := range a.People {
+				key, ok := cfg.NotionMember[u.Name]
+				if !ok {
+					// guests come to meetings too.
+					log.Printf("INFO: attendance: %v is not a member, skipped", u.Name)
+					continue
+				}
+				res[key] = true
+			}
+		}
+		if !v.HasMore {
+			return res, nil
+		}
+		req.StartCursor = v.NextCursor
+	}
+}
+
+func attendanceFromFile(cfg *config.Config, since time.Time) (map[string]bool, error) {
+	data, err := os.ReadFile(cfg.AttendanceFile)
+	if err != nil {
+		return nil, err
+	}
+	var meetings []Meeting
+	if err := yaml.Unmarshal(data, &meetings); err != nil {
+		return nil, err
+	}
+	y, mo, d := since.Date()
+	day := time.Date(y, mo, d, 0, 0, 0, 0, since.Location())
+	res := make(map[string]bool)
+	for _, m := range meetings {
+		date, err := time.ParseInLocation("2006-01-02", m.Date, since.Location())
+		if err != nil {
+			return nil, fmt.Errorf("bad meeting date %v: %w", m.Date, err)
+		}
+		if date.Before(day) {
+			continue
+		}
+		for _, name := range m.Members {
+			res[cfg.MemberKey(name)] = true
+		}
+	}
+	return res, nil
+}
+
+// fillActivity marks members of a book poll active if they attended during
+// the activity period. Inactive members get Rules.InactiveBudget.
+func fillActivity(cfg *config.Config, p *Poll) error {
+	if p.Type != TypeBook {
+		return nil
+	}
+	days := cfg.ActivityDays
+	if days == 0 {
+		days = DefaultActivityDays
+	}
+	active, ok, err := ReadAttendance(cfg, time.Now().AddDate(0, 0, -days))
+	if err != nil || !ok {
+		return err
+	}
+	for name := range p.members {
+		s := p.State[name]
+		s.ActivityChecked = true
+		s.Activity = active[name]
+		p.State[name] = s
+	}
+	return nil
+}
+
+// Members returns keys of everyone who may vote, sorted by their names.
+func (p *Poll) Members() []string {
+	res := make([]string, 0, len(p.members))
+	for name := range p.members {
+		res = append(res, name)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return p.MemberName(res[i]) < p.MemberName(res[j])
+	})
+	return res
+}
+
+// MemberName returns the tg nickname of the member, or the notion name if
+// the member has none.
+func (p *Poll) MemberName(member string) string {
+	if u := p.usernames[member]; u != "" {
+		return u
+	}
+	if n := p.members[member]; n != "" {
+		return n
+	}
+	return member
+}
+
+// Mention returns how the member is mentioned in messages.
+func (p *Poll) Mention(member string) string {
+	if u := p.usernames[member]; u != "" {
+		return "@" + u
+	}
+	return p.MemberName(member)
+}
+
+// Active reports whether member gets the whole budget.
+func (p *Poll) Active(member string) bool {
+	s := p.State[member]
+	return !s.ActivityChecked || s.Activity
+}
+
+// CanSetActivity reports whether name may override activity of members.
+func (p *Poll) CanSetActivity(name string) bool {
+	return !p.Closed() && p.Type == TypeBook && p.IsAdmin(name)
+}
+
+// SetActivity overrides activity of member computed from attendance.
+// Points already given by member must fit the new budget.
+func (p *Poll) SetActivity(name, member string, activity bool) error {
+	if !p.CanSetActivity(name) {
+		return fmt.Errorf("you cant set activity: %w", ErrForbidden)
+	}
+	if _, ok := p.members[member]; !ok {
+		return fmt.Errorf("unknown member: %v", member)
+	}
+	return p.do(Event{User: name, Action: ActionActivity, Member: member, Value: activity})
+}
+
+func (p *Poll) setActivity(member string, activity bool) error {
+	s := p.State[member]
+	s.ActivityChecked = true
+	s.Activity = activity
+	if err := p.check(member, s); err != nil {
+		return err
+	}
+	p.State[member] = s
+	return nil
+}
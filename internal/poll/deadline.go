@@ -0,0 +1,99 @@
+package poll
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTimezone is used when a poll has a deadline but no timezone.
+const DefaultTimezone = "Europe/Moscow"
+
+// Location returns the timezone deadlines of the poll are shown in.
+func (p *Poll) Location() *time.Location {
+	tz := p.Timezone
+	if tz == "" {
+		tz = DefaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// checkTimezone validates the timezone and moves the deadline to it.
+func (p *Poll) checkTimezone() error {
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return err
+		}
+	}
+	p.Deadline = p.Deadline.In(p.Location())
+	return nil
+}
+
+// SetDeadline sets the deadline in the poll timezone.
+func (p *Poll) SetDeadline(name string, t time.Time) error {
+	t = t.In(p.Location())
+	return p.do(Event{User: name, Action: ActionDeadline, Deadline: &t})
+}
+
+// TimeLeft returns time left until the deadline, false if the poll has none.
+func (p *Poll) TimeLeft(now time.Time) (time.Duration, bool) {
+	if p.Deadline.IsZero() {
+		return 0, false
+	}
+	left := p.Deadline.Sub(now)
+	if left < 0 {
+		left = 0
+	}
+	return left, true
+}
+
+// Expired reports whether the deadline has passed.
+func (p *Poll) Expired(now time.Time) bool {
+	return !p.Deadline.IsZero() && !now.Before(p.Deadline)
+}
+
+// CloseExpired closes the poll if its deadline has passed, a strict quorum
+// not met keeps it open. It reports whether the poll was closed by this call.
+func (p *Poll) CloseExpired(now time.Time) bool {
+	if p.Phase != PhaseVoting || !p.Expired(now) || p.quorumHolds() {
+		return false
+	}
+	return p.move("", PhaseClosed) == nil
+}
+
+// CanExtend reports whether name may move the deadline of a poll being
+// voted or closed by it.
+func (p *Poll) CanExtend(name string) bool {
+	return (p.Phase == PhaseVoting || p.Phase == PhaseClosed) && !p.Deadline.IsZero() && p.IsAdmin(name)
+}
+
+// Extend moves the deadline. A deadline that has already passed is moved
+// from now, so an extended poll always gets the whole extension.
+func (p *Poll) Extend(name string, now time.Time, d time.Duration) error {
+	from := p.Deadline
+	if from.Before(now) {
+		from = now
+	}
+	return p.SetDeadline(name, from.Add(d))
+}
+
+// Countdown formats time left until the deadline.
+func Countdown(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	if days > 0 {
+		return fmt.Sprintf("%vд %vч %vм", int(days), int(hours), int(d/time.Minute))
+	}
+	return fmt.Sprintf("%vч %vм", int(hours), int(d/time.Minute))
+}
+
+// DeadlineString formats the deadline in the poll timezone.
+func (p *Poll) DeadlineString() string {
+	return p.Deadline.In(p.Location()).Format("02.01 15:04 MST")
+}
@@ -0,0 +1,35 @@
+package poll
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrForbidden is wrapped by errors of actions the user may not do now.
+var ErrForbidden = errors.New("not allowed")
+
+// ErrBadProposal is wrapped by errors of proposals ParseProposal can't read.
+var ErrBadProposal = errors.New("bad proposal")
+
+// Rules a vote may break, see RuleError.
+const (
+	RuleMaxVariants   = "max_variants"
+	RuleMaxPerVariant = "max_per_variant"
+	RuleBudget        = "budget"
+	RuleVetoed        = "vetoed"
+	RuleBallot        = "ballot"
+)
+
+// RuleError is returned for a vote breaking a rule of the poll.
+type RuleError struct {
+	Rule string
+	// Limit the vote exceeds, if the rule has one.
+	Limit uint
+}
+
+func (e *RuleError) Error() string {
+	if e.Limit == 0 {
+		return fmt.Sprintf("vote breaks %v", e.Rule)
+	}
+	return fmt.Sprintf("vote breaks %v of %v", e.Rule, e.Limit)
+}
@@ -0,0 +1,270 @@
+package poll
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Journal actions.
+const (
+	// ActionInit starts a journal with the state the poll had at that moment.
+	ActionInit     = "init"
+	ActionVote     = "vote"
+	ActionUnvote   = "unvote"
+	ActionPhase    = "phase"
+	ActionActivity = "activity"
+	ActionDeadline = "deadline"
+	ActionTie      = "tie"
+	ActionProxy    = "proxy"
+	ActionPropose  = "propose"
+	ActionVeto     = "veto"
+	ActionUnveto   = "unveto"
+)
+
+// Actions of journals written before phases, they are replayed as phase changes.
+const (
+	actionStop   = "stop"
+	actionResume = "resume"
+	actionOpen   = "open"
+)
+
+// Event is a single change of a poll recorded in its journal.
+// Events without a user are made by the bot itself or by mitkactl.
+type Event struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	Action string    `json:"action"`
+
+	Vote    *Vote  `json:"vote,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	// Short is a variant key of journals written before variant IDs.
+	Short string `json:"short,omitempty"`
+	// Member is a member the user acts for: sets activity or a proxy of,
+	// or votes for by proxy. The user acts for themselves if empty.
+	Member   string           `json:"member,omitempty"`
+	Proxy    string           `json:"proxy,omitempty"`
+	Value    bool             `json:"value,omitempty"`
+	Deadline *time.Time       `json:"deadline,omitempty"`
+	State    map[string]State `json:"state,omitempty"`
+	// Phase is set by a phase event or was there when the journal started.
+	// Init events without it have Value telling whether the poll was closed.
+	Phase string `json:"phase,omitempty"`
+	// Proposals are made by a propose event or were there when the journal started.
+	Proposals []Proposal `json:"proposals,omitempty"`
+}
+
+func (e Event) String() string {
+	user := e.User
+	if user == "" {
+		user = "-"
+	}
+	res := fmt.Sprintf("%v %v %v", e.Time.Format(time.RFC3339), user, e.Action)
+	if e.Member != "" {
+		res += " " + e.Member
+	}
+	switch e.Action {
+	case ActionVote:
+		res += fmt.Sprintf(" %v %v", e.Vote.Variant, e.Vote.Count)
+	case ActionUnvote, ActionTie, ActionVeto, ActionUnveto:
+		res += " " + e.Variant
+	case ActionProxy:
+		res += " " + e.Proxy
+	case ActionPhase:
+		res += " " + e.Phase
+	case ActionPropose:
+		for _, pr := range e.Proposals {
+			res += " " + pr.Title
+		}
+	case ActionActivity:
+		res += fmt.Sprintf(" %v", e.Value)
+	case ActionDeadline:
+		if e.Deadline != nil {
+			res += " " + e.Deadline.Format(time.RFC3339)
+		}
+	}
+	return res
+}
+
+// do applies the event and keeps it to be written to the journal on Save.
+func (p *Poll) do(e Event) error {
+	e.Time = time.Now()
+	if err := p.apply(e); err != nil {
+		return err
+	}
+	p.pending = append(p.pending, e)
+	return nil
+}
+
+func (p *Poll) apply(e Event) error {
+	// journals written before user IDs have tg nicknames.
+	e.User = p.memberKey(e.User)
+	e.Member = p.memberKey(e.Member)
+	e.Proxy = p.memberKey(e.Proxy)
+	member := e.Member
+	if member == "" {
+		member = e.User
+	}
+	switch e.Action {
+	case ActionInit:
+		p.State = make(map[string]State, len(e.State))
+		for name, s := range p.migrateState(e.State) {
+			s.Votes = p.migrateVotes(s.Votes)
+			p.State[name] = s
+		}
+		switch {
+		case e.Phase != "":
+			p.setPhase(e.Phase, e.Time)
+		case e.Value:
+			p.setPhase(PhaseClosed, e.Time)
+		default:
+			p.setPhase(PhaseVoting, e.Time)
+		}
+		p.Proposals = nil
+		for _, pr := range e.Proposals {
+			pr.Member = p.memberKey(pr.Member)
+			p.Proposals = append(p.Proposals, pr)
+		}
+		p.Deadline = time.Time{}
+		if e.Deadline != nil {
+			p.Deadline = *e.Deadline
+		}
+	case ActionVote:
+		if e.Vote == nil {
+			return fmt.Errorf("vote event without vote")
+		}
+		vote := *e.Vote
+		vote.Variant = p.migrateID(vote.Variant, vote.Short)
+		vote.Short = ""
+		if member != e.User {
+			vote.By = e.User
+		}
+		return p.vote(member, vote)
+	case ActionUnvote:
+		return p.delVote(member, p.migrateID(e.Variant, e.Short))
+	case ActionVeto:
+		return p.veto(member, e.Variant)
+	case ActionUnveto:
+		return p.unveto(member, e.Variant)
+	case ActionPhase:
+		if err := checkPhase(e.Phase); err != nil {
+			return err
+		}
+		p.setPhase(e.Phase, e.Time)
+	case actionStop:
+		p.setPhase(PhaseClosed, e.Time)
+	case actionResume, actionOpen:
+		p.setPhase(PhaseVoting, e.Time)
+	case ActionActivity:
+		return p.setActivity(member, e.Value)
+	case ActionProxy:
+		p.setProxy(member, e.Proxy)
+	case ActionPropose:
+		for _, pr := range e.Proposals {
+			pr.Member = p.memberKey(pr.Member)
+			p.Proposals = append(p.Proposals, pr)
+		}
+	case ActionDeadline:
+		if e.Deadline == nil {
+			return fmt.Errorf("deadline event without deadline")
+		}
+		p.Deadline = *e.Deadline
+	case ActionTie:
+		p.breakTie(p.migrateID(e.Variant, e.Short))
+	default:
+		return fmt.Errorf("unknown action: %v", e.Action)
+	}
+	return nil
+}
+
+// Events reads the journal of the poll.
+func (p *Poll) Events() ([]Event, error) {
+	return p.store.Events(p.name)
+}
+
+// Save stores the poll with pending events. A new journal starts with the
+// state the poll had before the pending events.
+func (p *Poll) Save() error {
+	events := p.pending
+	if !p.journaled && len(events) > 0 {
+		events = append([]Event{p.initial}, events...)
+	}
+	p.Version++
+	data, err := yaml.Marshal(p)
+	if err == nil {
+		err = p.store.Save(p.name, p.Version-1, data, events)
+	}
+	if err != nil {
+		p.Version--
+		return err
+	}
+	p.pending = nil
+	p.journaled = p.journaled || len(events) > 0
+	if !p.journaled {
+		// a journal started later begins with the state saved now.
+		p.initial = p.initEvent()
+	}
+	return nil
+}
+
+// initEvent captures the current state to start a journal from.
+func (p *Poll) initEvent() Event {
+	state := make(map[string]State, len(p.State))
+	for name, s := range p.State {
+		s.Votes = append([]Vote(nil), s.Votes...)
+		s.Vetoes = append([]string(nil), s.Vetoes...)
+		state[name] = s
+	}
+	e := Event{
+		Time:      time.Now(),
+		Action:    ActionInit,
+		State:     state,
+		Phase:     p.Phase,
+		Proposals: append([]Proposal(nil), p.Proposals...),
+	}
+	if !p.Deadline.IsZero() {
+		deadline := p.Deadline
+		e.Deadline = &deadline
+	}
+	return e
+}
+
+// replay rebuilds the poll state from events made before until.
+// Disabled members are taken from the current state, they are set by hand.
+func (p *Poll) replay(events []Event, until time.Time) (*Poll, error) {
+	r := *p
+	r.replaying = true
+	defer func() { r.replaying = false }()
+	r.pending = nil
+	r.State = make(map[string]State)
+	r.Phase = PhaseDraft
+	r.PhaseTimes = nil
+	r.TieOrder = nil
+	r.Proposals = nil
+	for i, e := range events {
+		if e.Time.After(until) {
+			break
+		}
+		if err := r.apply(e); err != nil {
+			return nil, fmt.Errorf("journal event %v: %w", i+1, err)
+		}
+	}
+	for name, s := range p.State {
+		if s.Disabled {
+			rs := r.State[name]
+			rs.Disabled = true
+			r.State[name] = rs
+		}
+	}
+	return &r, nil
+}
+
+// Replay returns the poll as it was at the moment.
+func (p *Poll) Replay(until time.Time) (*Poll, error) {
+	events, err := p.Events()
+	if err != nil {
+		return nil, err
+	}
+	return p.replay(events, until)
+}
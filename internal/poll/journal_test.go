@@ -0,0 +1,180 @@
+package poll
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/molchalin/mitkabot/internal/config"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg := &config.Config{
+		StoragePath: t.TempDir(),
+		Members: []config.Member{
+			{Username: "alice", Notion: "Alice"},
+			{Username: "bob", Notion: "Bob"},
+			{Username: "carol", Notion: "Carol"},
+		},
+		Admins: []string{"alice"},
+	}
+	cfg.Index()
+	return cfg
+}
+
+func testPoll(t *testing.T, cfg *config.Config, name string) *Poll {
+	t.Helper()
+	p, err := CreatePoll(cfg, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Method = MethodPoints
+	p.Rules.setDefaults()
+	p.Variants = []Variant{{ID: "a", Text: "A"}, {ID: "b", Text: "B"}, {ID: "c", Text: "C"}}
+	p.setPhase(PhaseVoting, time.Now())
+	return p
+}
+
+func mustVote(t *testing.T, p *Poll, name, id string, count uint) {
+	t.Helper()
+	if err := p.Vote(name, Vote{Variant: id, Count: count}); err != nil {
+		t.Fatalf("vote of %v: %v", name, err)
+	}
+}
+
+func votes(p *Poll, name string) map[string]uint {
+	res := make(map[string]uint)
+	for _, v := range p.State[name].Votes {
+		res[v.Variant] = v.Count
+	}
+	return res
+}
+
+func TestCreateVoteReopen(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	mustVote(t, p, "alice", "a", 5)
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenPoll(cfg, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(r, "alice"); got["a"] != 5 || len(got) != 1 {
+		t.Errorf("votes after reopen = %v, want a:5", got)
+	}
+	events, err := r.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Action != ActionInit || events[1].Action != ActionVote {
+		t.Errorf("journal = %v, want init and vote", events)
+	}
+}
+
+func TestRunoffReopen(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	mustVote(t, p, "alice", "a", 5)
+	mustVote(t, p, "bob", "b", 4)
+	mustVote(t, p, "carol", "c", 3)
+	if err := p.Stop("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.CreateRunoff("p_2", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustVote(t, r, "bob", "a", 2)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenPoll(cfg, "p_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(r, "bob"); got["a"] != 2 {
+		t.Errorf("runoff votes after reopen = %v, want a:2", got)
+	}
+	if r.Phase != PhaseVoting {
+		t.Errorf("runoff phase = %v, want %v", r.Phase, PhaseVoting)
+	}
+}
+
+func TestReopenWithLoweredRules(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	mustVote(t, p, "alice", "a", 8)
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	p.Rules.Budget = 5
+	p.Rules.MaxPerVariant = 5
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenPoll(cfg, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(r, "alice"); got["a"] != 8 {
+		t.Errorf("votes after reopen = %v, want a:8", got)
+	}
+	if err := r.Vote("bob", Vote{Variant: "b", Count: 6}); err == nil {
+		t.Errorf("new vote over the lowered budget is accepted")
+	}
+}
+
+func TestSaveRetryAfterFailedWrite(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	mustVote(t, p, "alice", "a", 5)
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DelVote("alice", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// a directory in place of the backup makes its write fail.
+	bak := filepath.Join(cfg.StoragePath, "p.yml.bak")
+	if err := os.MkdirAll(filepath.Join(bak, "x"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(); err == nil {
+		t.Fatal("save over a broken backup succeeded")
+	}
+	if err := os.RemoveAll(bak); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenPoll(cfg, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(r, "alice"); len(got) != 0 {
+		t.Errorf("votes after reopen = %v, want none", got)
+	}
+	events, err := r.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Errorf("journal = %v, want init, vote and unvote", events)
+	}
+}
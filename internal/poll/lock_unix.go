@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package poll
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock shared by the bot and mitkactl.
+func lockFile(filename string) (func(), error) {
+	f, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
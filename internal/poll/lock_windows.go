@@ -0,0 +1,6 @@
+package poll
+
+// lockFile does nothing on windows, the bot is never run there.
+func lockFile(filename string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,186 @@
+package poll
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// MethodPoints spreads a budget of points over variants, see Rules.
+	MethodPoints = "points"
+	// MethodRanked orders variants by preference, the first choices win.
+	MethodRanked = "ranked"
+	// MethodBorda orders variants by preference, each place is worth points.
+	MethodBorda = "borda"
+	// MethodApproval marks any number of variants as acceptable.
+	MethodApproval = "approval"
+)
+
+// method is a voting scheme. It validates ballots, decides which values a
+// member may assign next and how a single ballot contributes to the result.
+type method interface {
+	// values returns values a member may assign to the next variant.
+	// A single value means no choice has to be offered.
+	values(p *Poll, name string) []uint
+	check(p *Poll, s State) error
+	canVote(p *Poll, name string) bool
+	score(p *Poll, s State) map[string]uint
+	// ranked reports whether Vote.Count is a place rather than points.
+	ranked() bool
+}
+
+var methods = map[string]method{
+	MethodPoints:   pointsMethod{},
+	MethodRanked:   rankedMethod{},
+	MethodBorda:    bordaMethod{},
+	MethodApproval: approvalMethod{},
+}
+
+func (p *Poll) method() method {
+	if m, ok := methods[p.Method]; ok {
+		return m
+	}
+	return pointsMethod{}
+}
+
+func checkMethod(name string) error {
+	if _, ok := methods[name]; !ok {
+		return fmt.Errorf("unknown method: %v", name)
+	}
+	return nil
+}
+
+type pointsMethod struct{}
+
+func (pointsMethod) values(p *Poll, name string) []uint {
+	var res []uint
+	for i := uint(1); i <= p.Rules.MaxPerVariant && i+p.Points(name) <= p.Budget(name); i++ {
+		res = append(res, i)
+	}
+	return res
+}
+
+func (pointsMethod) check(p *Poll, s State) error {
+	if uint(len(s.Votes)) > p.Rules.MaxVariants {
+		return &RuleError{Rule: RuleMaxVariants, Limit: p.Rules.MaxVariants}
+	}
+	var sum uint
+	for _, v := range s.Votes {
+		if v.Count > p.Rules.MaxPerVariant {
+			return &RuleError{Rule: RuleMaxPerVariant, Limit: p.Rules.MaxPerVariant}
+		}
+		sum += v.Count
+	}
+	if budget := p.Rules.budget(s); sum > budget {
+		return &RuleError{Rule: RuleBudget, Limit: budget}
+	}
+	return nil
+}
+
+func (pointsMethod) canVote(p *Poll, name string) bool {
+	return p.Points(name) < p.Budget(name) && uint(len(p.GetViewNotEmpty(name))) < p.Rules.MaxVariants
+}
+
+func (pointsMethod) score(p *Poll, s State) map[string]uint {
+	res := make(map[string]uint, len(s.Votes))
+	for _, v := range s.Votes {
+		res[v.Variant] += v.Count
+	}
+	return res
+}
+
+func (pointsMethod) ranked() bool { return false }
+
+// rankedMethod counts first choices. Places must form a sequence 1..n.
+type rankedMethod struct{}
+
+func (rankedMethod) values(p *Poll, name string) []uint {
+	return []uint{uint(len(p.GetViewNotEmpty(name)) + 1)}
+}
+
+func (rankedMethod) check(p *Poll, s State) error {
+	return checkPlaces(s.Votes)
+}
+
+func (rankedMethod) canVote(p *Poll, name string) bool {
+	return !p.State[name].Disabled && len(p.GetViewNotEmpty(name)) < len(p.GetView(name))
+}
+
+func (rankedMethod) score(p *Poll, s State) map[string]uint {
+	for _, v := range s.Votes {
+		if v.Count == 1 {
+			return map[string]uint{v.Variant: 1}
+		}
+	}
+	return nil
+}
+
+func (rankedMethod) ranked() bool { return true }
+
+// bordaMethod gives len(Variants)-place+1 points for every place.
+type bordaMethod struct{ rankedMethod }
+
+func (bordaMethod) score(p *Poll, s State) map[string]uint {
+	res := make(map[string]uint, len(s.Votes))
+	n := uint(len(p.Variants))
+	for _, v := range s.Votes {
+		if v.Count <= n {
+			res[v.Variant] = n - v.Count + 1
+		}
+	}
+	return res
+}
+
+type approvalMethod struct{}
+
+func (approvalMethod) values(p *Poll, name string) []uint {
+	return []uint{1}
+}
+
+func (approvalMethod) check(p *Poll, s State) error {
+	for _, v := range s.Votes {
+		if v.Count != 1 {
+			return &RuleError{Rule: RuleBallot}
+		}
+	}
+	return nil
+}
+
+func (approvalMethod) canVote(p *Poll, name string) bool {
+	return !p.State[name].Disabled && len(p.GetViewNotEmpty(name)) < len(p.GetView(name))
+}
+
+func (approvalMethod) score(p *Poll, s State) map[string]uint {
+	res := make(map[string]uint, len(s.Votes))
+	for _, v := range s.Votes {
+		res[v.Variant] = 1
+	}
+	return res
+}
+
+func (approvalMethod) ranked() bool { return false }
+
+func checkPlaces(votes []Vote) error {
+	seen := make(map[uint]bool, len(votes))
+	for _, v := range votes {
+		if v.Count == 0 || v.Count > uint(len(votes)) || seen[v.Count] {
+			return &RuleError{Rule: RuleBallot}
+		}
+		seen[v.Count] = true
+	}
+	return nil
+}
+
+// renumber closes gaps in places left after a vote was deleted.
+func renumber(votes []Vote) {
+	idx := make([]int, len(votes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return votes[idx[i]].Count < votes[idx[j]].Count
+	})
+	for place, i := range idx {
+		votes[i].Count = uint(place + 1)
+	}
+}
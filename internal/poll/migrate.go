@@ -0,0 +1,108 @@
+package poll
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/molchalin/mitkabot/internal/config"
+)
+
+// migrate moves a poll saved before variant IDs to them: variants without
+// an ID get one derived from the text, votes and the tie order keyed by
+// Variant.Short are rekeyed by IDs. Members are rekeyed by migrateMembers.
+func (p *Poll) migrate() {
+	p.migrateMembers()
+	seen := make(map[string]bool, len(p.Variants))
+	for _, v := range p.Variants {
+		seen[v.ID] = true
+	}
+	for i, v := range p.Variants {
+		if v.ID != "" {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(v.Text))
+		id := fmt.Sprintf("v%08x", h.Sum32())
+		for n := 2; seen[id]; n++ {
+			id = fmt.Sprintf("v%08x_%v", h.Sum32(), n)
+		}
+		seen[id] = true
+		p.Variants[i].ID = id
+	}
+	for name, s := range p.State {
+		s.Votes = p.migrateVotes(s.Votes)
+		p.State[name] = s
+	}
+	for i, id := range p.TieOrder {
+		if !seen[id] {
+			p.TieOrder[i] = p.migrateID("", id)
+		}
+	}
+}
+
+// migrateID returns the ID of a variant keyed by id, or by short if the key
+// was written before variant IDs. Unknown shorts are kept as they are.
+func (p *Poll) migrateID(id, short string) string {
+	if id != "" || short == "" {
+		return id
+	}
+	for _, v := range p.Variants {
+		if v.Short() == short {
+			return v.ID
+		}
+	}
+	return short
+}
+
+// migrateVotes returns a copy of votes keyed by variant IDs.
+func (p *Poll) migrateVotes(votes []Vote) []Vote {
+	if votes == nil {
+		return nil
+	}
+	res := make([]Vote, len(votes))
+	for i, v := range votes {
+		res[i] = Vote{Variant: p.migrateID(v.Variant, v.Short), Count: v.Count, By: v.By}
+	}
+	return res
+}
+
+// memberKey returns the key of a member known by a tg nickname before
+// members got user IDs, keys are returned as they are.
+func (p *Poll) memberKey(name string) string {
+	if name == "" {
+		return ""
+	}
+	return config.MemberKey(p.usernames, name)
+}
+
+// migrateMembers rekeys everything saved by tg nicknames by member keys.
+func (p *Poll) migrateMembers() {
+	p.State = p.migrateState(p.State)
+	for i, v := range p.Variants {
+		p.Variants[i].Author = p.memberKey(v.Author)
+	}
+	for i, pr := range p.Proposals {
+		p.Proposals[i].Member = p.memberKey(pr.Member)
+	}
+	for i, name := range p.Quorum.Required {
+		p.Quorum.Required[i] = p.memberKey(name)
+	}
+}
+
+// migrateState returns a copy of the state keyed by member keys.
+func (p *Poll) migrateState(state map[string]State) map[string]State {
+	res := make(map[string]State, len(state))
+	for name, s := range state {
+		s.Proxy = p.memberKey(s.Proxy)
+		votes := make([]Vote, len(s.Votes))
+		for i, v := range s.Votes {
+			v.By = p.memberKey(v.By)
+			votes[i] = v
+		}
+		if s.Votes != nil {
+			s.Votes = votes
+		}
+		res[p.memberKey(name)] = s
+	}
+	return res
+}
@@ -0,0 +1,181 @@
+package poll
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jomei/notionapi"
+	"github.com/molchalin/mitkabot/internal/config"
+)
+
+// Proposal is a book a member nominated from the bot.
+type Proposal struct {
+	Title  string `yaml:"title" json:"title"`
+	Author string `yaml:"author" json:"author"`
+	Link   string `yaml:"link,omitempty" json:"link,omitempty"`
+	// Member is the key of the member who proposed the book.
+	Member string `yaml:"member" json:"member"`
+	// ID is a Notion page of the book, it becomes Variant.ID.
+	ID string `yaml:"id" json:"id"`
+}
+
+func (pr Proposal) String() string {
+	res := fmt.Sprintf("%v — %v", pr.Title, pr.Author)
+	if pr.Link != "" {
+		res = fmt.Sprintf("[%v](%v) — %v", pr.Title, pr.Link, pr.Author)
+	}
+	return res
+}
+
+// ParseProposal reads a proposal from lines of a message: a title, an author
+// and an optional link.
+func ParseProposal(text string) (Proposal, error) {
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) < 2 || len(lines) > 3 {
+		return Proposal{}, fmt.Errorf("%w: must have 2 or 3 lines, got %v", ErrBadProposal, len(lines))
+	}
+	pr := Proposal{Title: lines[0], Author: lines[1]}
+	if len(lines) == 3 {
+		u, err := url.Parse(lines[2])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Proposal{}, fmt.Errorf("%w: bad link: %v", ErrBadProposal, lines[2])
+		}
+		pr.Link = lines[2]
+	}
+	return pr, nil
+}
+
+// ProposalsOf returns books name has proposed.
+func (p *Poll) ProposalsOf(name string) []Proposal {
+	var res []Proposal
+	for _, pr := range p.Proposals {
+		if pr.Member == name {
+			res = append(res, pr)
+		}
+	}
+	return res
+}
+
+func (p *Poll) CanPropose(name string) bool {
+	return p.Phase == PhaseNomination && uint(len(p.ProposalsOf(name))) < p.Rules.MaxProposals
+}
+
+// Propose adds the book to the Notion book DB on behalf of name.
+func (p *Poll) Propose(cfg *config.Config, name string, pr Proposal) error {
+	if !p.CanPropose(name) {
+		return fmt.Errorf("you cant propose: %w", ErrForbidden)
+	}
+	pr.Member = name
+	id, err := createBook(cfg, pr)
+	if err != nil {
+		return err
+	}
+	pr.ID = id
+	return p.do(Event{User: name, Action: ActionPropose, Proposals: []Proposal{pr}})
+}
+
+func (p *Poll) CanOpenVoting(name string) bool {
+	return p.beforeVoting() && p.IsAdmin(name)
+}
+
+func (p *Poll) beforeVoting() bool {
+	return p.Phase == PhaseDraft || p.Phase == PhaseNomination
+}
+
+// OpenVoting ends the nomination or the draft, variants of a book poll are
+// read from the book DB again. Empty name is mitkactl.
+func (p *Poll) OpenVoting(cfg *config.Config, name string) error {
+	if !p.beforeVoting() || name != "" && !p.IsAdmin(name) {
+		return fmt.Errorf("you cant open voting: %w", ErrForbidden)
+	}
+	if p.Type == TypeBook {
+		old := p.Variants
+		p.Variants = nil
+		if err := fillBooks(cfg, p); err != nil {
+			p.Variants = old
+			return err
+		}
+	}
+	return p.move(name, PhaseVoting)
+}
+
+// createBook adds a page of the proposal to the book DB, it returns the page ID.
+func createBook(cfg *config.Config, pr Proposal) (string, error) {
+	cl := notionapi.NewClient(notionapi.Token(cfg.NotionToken))
+	user, err := notionUser(cl, cfg.MemberNotion[pr.Member])
+	if err != nil {
+		return "", err
+	}
+	props := map[string]notionapi.Property{
+		"Книга": notionapi.TitleProperty{
+			Type: notionapi.PropertyTypeTitle,
+			Title: []notionapi.RichText{
+				{
+					Type: notionapi.ObjectTypeText,
+					Text: notionapi.Text{
+						Content: pr.Title,
+					},
+				},
+			},
+		},
+		"Автор": notionapi.RichTextProperty{
+			Type: notionapi.PropertyTypeRichText,
+			RichText: []notionapi.RichText{
+				{
+					Type: notionapi.ObjectTypeText,
+					Text: notionapi.Text{
+						Content: pr.Author,
+					},
+				},
+			},
+		},
+		"Кто предложил": notionapi.PeopleProperty{
+			Type:   notionapi.PropertyTypePeople,
+			People: []notionapi.User{{Object: notionapi.ObjectTypeUser, ID: user.ID}},
+		},
+	}
+	if pr.Link != "" {
+		props["Ссылка"] = notionapi.URLProperty{
+			Type: notionapi.PropertyTypeURL,
+			URL:  pr.Link,
+		}
+	}
+	page, err := cl.Page.Create(context.Background(), &notionapi.PageCreateRequest{
+		Parent: notionapi.Parent{
+			Type:       notionapi.ParentTypeDatabaseID,
+			DatabaseID: notionapi.DatabaseID(cfg.BookDB),
+		},
+		Properties: props,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(page.ID), nil
+}
+
+// notionUser finds a Notion user by the name a member has in MemberNotion.
+func notionUser(cl *notionapi.Client, name string) (*notionapi.User, error) {
+	var pg notionapi.Pagination
+	for {
+		res, err := cl.User.List(context.Background(), &pg)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range res.Results {
+			if u.Name == name {
+				return &u, nil
+			}
+		}
+		if !res.HasMore {
+			return nil, fmt.Errorf("unknown notion user: %v", name)
+		}
+		pg.StartCursor = res.NextCursor
+	}
+}
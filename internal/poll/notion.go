@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/jomei/notionapi"
 	"github.com/molchalin/mitkabot/internal/config"
@@ -12,49 +13,109 @@ import (
 var re = regexp.MustCompile(`\W+`)
 
 func mustNotion(cfg *config.Config, str string) string {
-	if v, ok := cfg.NotionTGMap[str]; ok {
+	if v, ok := cfg.NotionMember[str]; ok {
 		return v
 	}
 	panic("unknown user")
 }
 
-func CreatePollFromNotion(cfg *config.Config) error {
-	p, err := CreatePoll(cfg.PollFile)
+// CreatePollFromNotion creates a book poll in the phase: draft, nomination
+// or voting.
+func CreatePollFromNotion(cfg *config.Config, phase string) error {
+	return createPollFromNotion(cfg, TypeBook, cfg.ResultDB, phase, fillBooks)
+}
+
+// CreateReportPollFromNotion creates a poll for reviews of this month.
+// Review authors can't vote for their own reviews.
+func CreateReportPollFromNotion(cfg *config.Config, phase string) error {
+	if phase == PhaseNomination {
+		return fmt.Errorf("report polls have no nomination")
+	}
+	return createPollFromNotion(cfg, TypeReport, cfg.ReportResultDB, phase, fillReviews)
+}
+
+func createPollFromNotion(cfg *config.Config, typ, resultDB, phase string, fill func(*config.Config, *Poll) error) error {
+	if phase != PhaseDraft && phase != PhaseNomination && phase != PhaseVoting {
+		return fmt.Errorf("poll cant be created in phase %v", phase)
+	}
+	p, err := CreatePoll(cfg, cfg.PollFile)
 	if err != nil {
 		return err
 	}
-	p.Type = TypeBook
-	p.ResultDB = cfg.ResultDB
-	err = fillBooks(cfg, p)
+	p.setPhase(phase, time.Now())
+	p.Type = typ
+	p.ResultDB = resultDB
+	p.Method = cfg.Method
+	if p.Method == "" {
+		p.Method = MethodPoints
+	}
+	if err := checkMethod(p.Method); err != nil {
+		p.remove()
+		return err
+	}
+	p.TieBreak = cfg.TieBreak
+	if p.TieBreak == "" {
+		p.TieBreak = TieBreakNone
+	}
+	if err := checkTieBreak(p.TieBreak); err != nil {
+		p.remove()
+		return err
+	}
+	p.Secrecy = cfg.Secrecy
+	if p.Secrecy == "" {
+		p.Secrecy = SecrecyAdmins
+	}
+	if err := checkSecrecy(p.Secrecy); err != nil {
+		p.remove()
+		return err
+	}
+	p.Anonymous = cfg.Anonymous
+	p.Shuffle = cfg.Shuffle
+	p.Seed = time.Now().UnixNano()
+	p.Timezone = cfg.Timezone
+	err = fill(cfg, p)
+	if err == nil {
+		err = fillActivity(cfg, p)
+	}
 	if err != nil {
-		RemovePoll(cfg.PollFile)
+		p.remove()
 		return err
 	}
 	return p.Save()
 }
 
 func fillBooks(cfg *config.Config, p *Poll) error {
+	return fillVariants(cfg, p, cfg.BookDB, "Книга", "Кто предложил")
+}
+
+func fillReviews(cfg *config.Config, p *Poll) error {
+	return fillVariants(cfg, p, cfg.ReviewDB, "Рецензия", "Автор")
+}
+
+// fillVariants adds a variant for every page of the Notion DB.
+// titleProp and authorProp are names of title and people properties.
+func fillVariants(cfg *config.Config, p *Poll, db, titleProp, authorProp string) error {
 	cl := notionapi.NewClient(notionapi.Token(cfg.NotionToken))
 
-	v, err := cl.Database.Query(context.Background(), notionapi.DatabaseID(cfg.BookDB), nil)
+	v, err := cl.Database.Query(context.Background(), notionapi.DatabaseID(db), nil)
 	if err != nil {
 		return err
 	}
 
 	for _, v := range v.Results {
-		k, ok := v.Properties["Книга"].(*notionapi.TitleProperty)
+		k, ok := v.Properties[titleProp].(*notionapi.TitleProperty)
 		if !ok {
-			return fmt.Errorf("book cast error")
+			return fmt.Errorf("%v cast error", titleProp)
 		}
 		var res string
 		for _, t := range k.Title {
 			res += t.PlainText
 		}
 		if len(res) == 0 {
-			return fmt.Errorf("bad book: %v", k.Title)
+			return fmt.Errorf("bad %v: %v", titleProp, k.Title)
 		}
 
-		a, ok := v.Properties["Кто предложил"].(*notionapi.PeopleProperty)
+		a, ok := v.Properties[authorProp].(*notionapi.PeopleProperty)
 		if !ok {
 			return fmt.Errorf("author cast error")
 		}
@@ -62,14 +123,14 @@ func fillBooks(cfg *config.Config, p *Poll) error {
 			return fmt.Errorf("author cast error")
 		}
 		name := a.People[0].Name
-		tg, ok := cfg.NotionTGMap[name]
+		key, ok := cfg.NotionMember[name]
 		if !ok {
 			return fmt.Errorf("unknow user: %v", name)
 		}
 		p.Variants = append(p.Variants, Variant{
 			ID:     string(v.ID),
 			Text:   res,
-			Author: tg,
+			Author: key,
 		})
 	}
 	return nil
@@ -80,55 +141,127 @@ func PushResult(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
-	if !p.Closed {
-		return fmt.Errorf("poll is not closed")
+	if p.Phase != PhaseClosed {
+		return fmt.Errorf("poll is %v, not closed", p.Phase)
+	}
+	switch p.Type {
+	case TypeBook:
+		err = fillResult(cfg, p, "Выбор")
+	case TypeReport:
+		err = fillResult(cfg, p, "Рецензия")
+	default:
+		err = fmt.Errorf("unknown poll type: %v", p.Type)
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.move("", PhasePublished); err != nil {
+		return err
 	}
-	return fillBookResult(cfg, p)
+	return p.Save()
 }
 
-func fillBookResult(cfg *config.Config, p *Poll) error {
+// fillResult writes every vote to the results DB of the poll, or a total
+// of every variant if the poll is anonymous. Votes are written as points
+// the ballot gives, places of ranked ballots are turned into points.
+// relationProp is a name of the relation property to the voted page.
+func fillResult(cfg *config.Config, p *Poll, relationProp string) error {
 	cl := notionapi.NewClient(notionapi.Token(cfg.NotionToken))
-	shToVariant := make(map[string]Variant, len(p.Variants))
-	for _, v := range p.Variants {
-		shToVariant[v.Short()] = v
+	if p.Anonymous {
+		for _, v := range p.Result(false) {
+			if err := createResult(cl, p, relationProp, "Итог", "", v.ID, v.Count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range p.resultRows() {
+		var by string
+		if r.by != "" {
+			by = cfg.MemberNotion[r.by]
+		}
+		if err := createResult(cl, p, relationProp, cfg.MemberNotion[r.member], by, r.variant, r.count); err != nil {
+			return err
+		}
 	}
-	for uname, state := range p.State {
+	return nil
+}
+
+// resultRow is a vote or a veto of a member written to the results DB.
+type resultRow struct {
+	member  string
+	by      string
+	variant string
+	count   int
+}
+
+// resultRows returns votes and vetoes of every member, they add up to Result:
+// vetoes always count, votes of incomplete ballots don't.
+func (p *Poll) resultRows() []resultRow {
+	var res []resultRow
+	m := p.method()
+	for name, state := range p.State {
+		for _, id := range state.Vetoes {
+			res = append(res, resultRow{member: name, variant: id, count: -int(p.Rules.VetoPoints)})
+		}
+		if !p.complete(state) {
+			continue
+		}
+		scores := m.score(p, state)
 		for _, vote := range state.Votes {
-			_, err := cl.Page.Create(context.Background(), &notionapi.PageCreateRequest{
-				Parent: notionapi.Parent{
-					Type:       notionapi.ParentTypeDatabaseID,
-					DatabaseID: notionapi.DatabaseID(p.ResultDB),
-				},
-				Properties: map[string]notionapi.Property{
-					"Name": notionapi.TitleProperty{
-						Type: notionapi.PropertyTypeTitle,
-						Title: []notionapi.RichText{
-							{
-								Type: notionapi.ObjectTypeText,
-								Text: notionapi.Text{
-									Content: cfg.TGNotionMap[uname],
-								},
-							},
-						},
-					},
-					"Сколько баллов": notionapi.NumberProperty{
-						Type:   notionapi.PropertyTypeNumber,
-						Number: float64(vote.Count),
+			res = append(res, resultRow{member: name, by: vote.By, variant: vote.Variant, count: int(scores[vote.Variant])})
+		}
+	}
+	return res
+}
+
+// createResult adds a row to the results DB. by is a proxy who cast the
+// vote, it is written to "Кто голосовал" if not empty.
+func createResult(cl *notionapi.Client, p *Poll, relationProp, name, by, variant string, count int) error {
+	props := map[string]notionapi.Property{
+		"Name": notionapi.TitleProperty{
+			Type: notionapi.PropertyTypeTitle,
+			Title: []notionapi.RichText{
+				{
+					Type: notionapi.ObjectTypeText,
+					Text: notionapi.Text{
+						Content: name,
 					},
-					"Выбор": notionapi.RelationProperty{
-						Type: notionapi.PropertyTypeRelation,
-						Relation: []notionapi.Relation{
-							{
-								ID: notionapi.PageID(shToVariant[vote.Short].ID),
-							},
-						},
+				},
+			},
+		},
+		"Сколько баллов": notionapi.NumberProperty{
+			Type:   notionapi.PropertyTypeNumber,
+			Number: float64(count),
+		},
+		relationProp: notionapi.RelationProperty{
+			Type: notionapi.PropertyTypeRelation,
+			Relation: []notionapi.Relation{
+				{
+					ID: notionapi.PageID(variant),
+				},
+			},
+		},
+	}
+	if by != "" {
+		props["Кто голосовал"] = notionapi.RichTextProperty{
+			Type: notionapi.PropertyTypeRichText,
+			RichText: []notionapi.RichText{
+				{
+					Type: notionapi.ObjectTypeText,
+					Text: notionapi.Text{
+						Content: by,
 					},
 				},
-			})
-			if err != nil {
-				return err
-			}
+			},
 		}
 	}
-	return nil
+	_, err := cl.Page.Create(context.Background(), &notionapi.PageCreateRequest{
+		Parent: notionapi.Parent{
+			Type:       notionapi.ParentTypeDatabaseID,
+			DatabaseID: notionapi.DatabaseID(p.ResultDB),
+		},
+		Properties: props,
+	})
+	return err
 }
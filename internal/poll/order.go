@@ -0,0 +1,24 @@
+package poll
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// order returns indices of variants in the order name sees them. A shuffled
+// poll gives every member their own order, it is seeded by the poll and
+// the member so it stays the same between views.
+func (p *Poll) order(name string) []int {
+	if !p.Shuffle {
+		res := make([]int, len(p.Variants))
+		for i := range res {
+			res[i] = i
+		}
+		return res
+	}
+	h := fnv.New64a()
+	h.Write([]byte(p.name))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	return rand.New(rand.NewSource(int64(h.Sum64()) ^ p.Seed)).Perm(len(p.Variants))
+}
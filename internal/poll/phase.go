@@ -0,0 +1,123 @@
+package poll
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phases of a poll lifecycle.
+const (
+	// PhaseDraft is a poll being prepared, members can't do anything yet.
+	PhaseDraft = "draft"
+	// PhaseNomination takes proposals of books.
+	PhaseNomination = "nomination"
+	// PhaseVoting takes votes.
+	PhaseVoting = "voting"
+	// PhaseClosed has the final result, voting may be resumed.
+	PhaseClosed = "closed"
+	// PhasePublished has the result pushed to Notion, nothing changes any more.
+	PhasePublished = "published"
+)
+
+// transitions lists phases every phase may move to.
+var transitions = map[string][]string{
+	PhaseDraft:      {PhaseNomination, PhaseVoting},
+	PhaseNomination: {PhaseVoting},
+	PhaseVoting:     {PhaseClosed},
+	PhaseClosed:     {PhaseVoting, PhasePublished},
+	PhasePublished:  nil,
+}
+
+var phaseNames = map[string]string{
+	PhaseDraft:      "Подготовка",
+	PhaseNomination: "Выдвижение",
+	PhaseVoting:     "Голосование",
+	PhaseClosed:     "Закрыто",
+	PhasePublished:  "Опубликовано",
+}
+
+func checkPhase(phase string) error {
+	if _, ok := transitions[phase]; !ok {
+		return fmt.Errorf("unknown phase: %v", phase)
+	}
+	return nil
+}
+
+// PhaseName returns a name of the phase shown to members.
+func PhaseName(phase string) string {
+	return phaseNames[phase]
+}
+
+// CanMove reports whether the poll may move from its phase to the given one.
+func (p *Poll) CanMove(phase string) bool {
+	for _, to := range transitions[p.Phase] {
+		if to == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// move changes the phase on behalf of name, empty name is the bot or mitkactl.
+func (p *Poll) move(name, phase string) error {
+	if !p.CanMove(phase) {
+		return fmt.Errorf("cant move poll from %v to %v", p.Phase, phase)
+	}
+	return p.do(Event{User: name, Action: ActionPhase, Phase: phase})
+}
+
+// Move changes the phase by hand, name must be an admin unless empty.
+// Voting is opened by OpenVoting and results are published by PushResult.
+func (p *Poll) Move(name, phase string) error {
+	if name != "" && !p.IsAdmin(name) {
+		return fmt.Errorf("you cant change phase: %w", ErrForbidden)
+	}
+	if phase == PhaseVoting && p.Phase != PhaseClosed {
+		return fmt.Errorf("use OpenVoting to open voting")
+	}
+	if phase == PhasePublished {
+		return fmt.Errorf("use PushResult to publish")
+	}
+	return p.move(name, phase)
+}
+
+func (p *Poll) setPhase(phase string, t time.Time) {
+	p.Phase = phase
+	if p.PhaseTimes == nil {
+		p.PhaseTimes = make(map[string]time.Time)
+	}
+	p.PhaseTimes[phase] = t
+}
+
+// Closed reports whether voting has ended.
+func (p *Poll) Closed() bool {
+	return p.Phase == PhaseClosed || p.Phase == PhasePublished
+}
+
+// migratePhase sets the phase of a poll saved before phases.
+func (p *Poll) migratePhase() {
+	if p.Phase != "" {
+		return
+	}
+	switch {
+	case p.LegacyClosed:
+		p.Phase = PhaseClosed
+	case p.LegacyNomination:
+		p.Phase = PhaseNomination
+	default:
+		p.Phase = PhaseVoting
+	}
+	p.LegacyClosed, p.LegacyNomination = false, false
+}
+
+func (p *Poll) CanOpenNomination(name string) bool {
+	return p.Phase == PhaseDraft && p.Type == TypeBook && p.IsAdmin(name)
+}
+
+// OpenNomination lets members propose books.
+func (p *Poll) OpenNomination(name string) error {
+	if !p.CanOpenNomination(name) {
+		return fmt.Errorf("you cant open nomination: %w", ErrForbidden)
+	}
+	return p.move(name, PhaseNomination)
+}
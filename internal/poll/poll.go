@@ -2,58 +2,100 @@ package poll
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"sort"
+	"log"
 	"strings"
+	"time"
 
 	iuliia "github.com/mehanizm/iuliia-go"
 	"github.com/molchalin/mitkabot/internal/config"
 	"gopkg.in/yaml.v2"
 )
 
-const MaxVotes = 3
-
 const (
 	TypeBook   = "book"
 	TypeReport = "report"
 )
 
-func (s State) MaxPoints() uint {
-	if s.Disabled {
-		return 0
-	}
-	if !s.ActivityChecked || s.Activity {
-		return 10
-	}
-	return 7
-}
-
 type Poll struct {
-	filename string
+	name     string
+	store    Storage
+	Title    string           `yaml:"title,omitempty"`
 	Variants []Variant        `yaml:"variants"`
 	State    map[string]State `yaml:"state,omitempty"`
 	Type     string           `yaml:"type"`
+	Method   string           `yaml:"method,omitempty"`
 	ResultDB string           `yaml:"result_db"`
-	Closed   bool             `yaml:"closed"`
-
-	admins      []string          `yaml:"-"`
-	tgNotionMap map[string]string `yaml:"-"`
+	Rules    Rules            `yaml:"rules,omitempty"`
+	// Secrecy decides who sees the result before the poll is closed.
+	Secrecy string `yaml:"secrecy,omitempty"`
+	// Anonymous polls push only totals of variants, not ballots of members.
+	Anonymous bool `yaml:"anonymous,omitempty"`
+	// Shuffle shows variants to every member in their own order, see Poll.order.
+	Shuffle bool `yaml:"shuffle,omitempty"`
+	// Quorum needed to close the poll.
+	Quorum    Quorum     `yaml:"quorum,omitempty"`
+	Proposals []Proposal `yaml:"proposals,omitempty"`
+
+	// Phase of the poll lifecycle, PhaseTimes holds when it entered every phase.
+	Phase      string               `yaml:"phase"`
+	PhaseTimes map[string]time.Time `yaml:"phase_times,omitempty"`
+	// LegacyClosed and LegacyNomination are read from polls saved before
+	// phases to migrate them.
+	LegacyClosed     bool `yaml:"closed,omitempty"`
+	LegacyNomination bool `yaml:"nomination,omitempty"`
+	// Version grows on every Save, it detects concurrent changes.
+	Version uint64 `yaml:"version"`
+	// Deadline closes the poll automatically, zero means no deadline.
+	Deadline time.Time `yaml:"deadline,omitempty"`
+	// Timezone the deadline is shown in, DefaultTimezone if empty.
+	Timezone string `yaml:"timezone,omitempty"`
+
+	// Parent is a poll this one is a runoff of.
+	Parent string `yaml:"parent,omitempty"`
+	// Next is a runoff poll created from this one.
+	Next string `yaml:"next,omitempty"`
+
+	// TieBreak decides the order of variants with equal results.
+	TieBreak string `yaml:"tie_break,omitempty"`
+	// Seed of TieBreakLottery.
+	Seed int64 `yaml:"seed,omitempty"`
+	// TieOrder is an order of variants chosen by an admin for TieBreakAdmin.
+	TieOrder []string `yaml:"tie_order,omitempty"`
+
+	admins []string `yaml:"-"`
+	// members maps member keys to notion names, see config.Member.
+	members   map[string]string `yaml:"-"`
+	usernames map[string]string `yaml:"-"`
+
+	// pending events are written to the journal on Save.
+	pending []Event
+	// initial starts the journal if it doesn't exist yet.
+	initial   Event
+	journaled bool
+	// replaying polls apply recorded events without checking them against
+	// rules, which may have changed since the events were made.
+	replaying bool
 }
 
 type State struct {
-	Disabled        bool   `yaml:"disabled,omitempty"`
-	ActivityChecked bool   `yaml:"activity_checked,omitempty"`
-	Activity        bool   `yaml:"activity,omitempty"`
-	Votes           []Vote `yaml:"votes,omitempty"`
+	Disabled        bool   `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+	ActivityChecked bool   `yaml:"activity_checked,omitempty" json:"activity_checked,omitempty"`
+	Activity        bool   `yaml:"activity,omitempty" json:"activity,omitempty"`
+	Votes           []Vote `yaml:"votes,omitempty" json:"votes,omitempty"`
+	// Proxy is a member who may vote instead of this one.
+	Proxy string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// Vetoes are IDs of variants the member is against, see Rules.Vetoes.
+	Vetoes []string `yaml:"vetoes,omitempty" json:"vetoes,omitempty"`
 }
 
 type Variant struct {
 	Text   string `yaml:"text"`
 	Author string `yaml:"author"`
-	ID     string `yaml:"id"`
+	// ID is a Notion page of the variant. It keys votes, so it must not change.
+	ID string `yaml:"id"`
 }
 
+// Short is a key votes used before variant IDs, it is kept to migrate them.
 func (v Variant) Short() string {
 	short := iuliia.Wikipedia.Translate(v.Text)
 	words := strings.Split(short, " ")
@@ -71,108 +113,190 @@ func (v Variant) String() string {
 }
 
 type Vote struct {
-	Short string `yaml:"short_name"`
-	Count uint   `yaml:"count"`
+	// Variant is an ID of the voted variant.
+	Variant string `yaml:"variant" json:"variant"`
+	Count   uint   `yaml:"count" json:"count"`
+	// Short is a variant key of polls saved before variant IDs, see Poll.migrate.
+	Short string `yaml:"short_name,omitempty" json:"short_name,omitempty"`
+	// By is a proxy who cast the vote, empty if the member voted themselves.
+	By string `yaml:"by,omitempty" json:"by,omitempty"`
 }
 
 type View struct {
-	Text  string
-	Short string
-	Count uint
+	Text string
+	ID   string
+	// Count is points or a place of a ballot, or a total of a result which
+	// is negative if vetoes outweigh points.
+	Count int
+	// Index is a number of the variant in Poll.Variants, starting from 1.
 	Index uint
+	// Pos is a number of the variant in the order a member sees, zero if
+	// the poll is not shuffled.
+	Pos uint
+	// Place is a place in a result, tied views share it.
+	Place uint
 }
 
-func (v View) String() string {
-	return fmt.Sprintf("%v. %v - **%v**", v.Index, v.Text, v.Count)
+// Number returns the number the variant is shown under.
+func (v View) Number() uint {
+	if v.Pos > 0 {
+		return v.Pos
+	}
+	return v.Index
 }
 
-func pollFile(str string) string {
-	return filepath.Join("etc", str+".yml")
+func (v View) String() string {
+	return fmt.Sprintf("%v. %v - **%v**", v.Number(), v.Text, v.Count)
 }
 
-func CreatePoll(str string) (*Poll, error) {
-	f, err := os.OpenFile(pollFile(str), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+// CreatePoll reserves the name for a new poll in the storage of the config.
+func CreatePoll(cfg *config.Config, name string) (*Poll, error) {
+	store, err := NewStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p, err := createPoll(store, name)
 	if err != nil {
 		return nil, err
 	}
-	f.Close()
-	return &Poll{
-		filename: pollFile(str),
-		State:    make(map[string]State),
-	}, nil
+	p.members = cfg.MemberNotion
+	p.usernames = cfg.Usernames
+	p.admins = cfg.Admins
+	return p, nil
 }
 
-func RemovePoll(str string) {
-	os.Remove(pollFile(str))
+func createPoll(store Storage, name string) (*Poll, error) {
+	if err := store.Create(name); err != nil {
+		return nil, err
+	}
+	p := &Poll{
+		name:  name,
+		store: store,
+		State: make(map[string]State),
+	}
+	p.initial = p.initEvent()
+	return p, nil
 }
 
-func NewPoll(cfg *config.Config) (*Poll, error) {
-	filename := pollFile(cfg.PollFile)
-	f, err := os.Open(filename)
+// remove drops the poll which failed to be created.
+func (p *Poll) remove() {
+	p.store.Remove(p.name)
+}
+
+// ListPolls returns names of all stored polls.
+func ListPolls(cfg *config.Config) ([]string, error) {
+	store, err := NewStorage(cfg)
 	if err != nil {
 		return nil, err
 	}
+	return store.List()
+}
 
-	defer f.Close()
-
-	dec := yaml.NewDecoder(f)
+func NewPoll(cfg *config.Config) (*Poll, error) {
+	return OpenPoll(cfg, cfg.PollFile)
+}
 
-	p := &Poll{
-		filename:    filename,
-		State:       make(map[string]State),
-		tgNotionMap: cfg.TGNotionMap,
-		admins:      cfg.Admins,
+// OpenPoll reads the poll stored under the name, the state is rebuilt from
+// its journal if there is one.
+func OpenPoll(cfg *config.Config, name string) (*Poll, error) {
+	store, err := NewStorage(cfg)
+	if err != nil {
+		return nil, err
 	}
-	err = dec.Decode(p)
+	data, err := store.Load(name)
 	if err != nil {
 		return nil, err
 	}
+	p := &Poll{
+		name:      name,
+		store:     store,
+		State:     make(map[string]State),
+		members:   cfg.MemberNotion,
+		usernames: cfg.Usernames,
+		admins:    cfg.Admins,
+	}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
 	if p.Type == "" {
 		p.Type = TypeBook
 	}
+	if p.Method == "" {
+		p.Method = MethodPoints
+	}
+	if err := checkMethod(p.Method); err != nil {
+		return nil, err
+	}
+	if p.TieBreak == "" {
+		p.TieBreak = TieBreakNone
+	}
+	if err := checkTieBreak(p.TieBreak); err != nil {
+		return nil, err
+	}
+	p.migratePhase()
+	if err := checkPhase(p.Phase); err != nil {
+		return nil, err
+	}
+	if p.Secrecy == "" {
+		p.Secrecy = SecrecyAdmins
+	}
+	if err := checkSecrecy(p.Secrecy); err != nil {
+		return nil, err
+	}
+	if err := p.checkTimezone(); err != nil {
+		return nil, err
+	}
+	p.Rules.setDefaults()
+	p.migrate()
+	if err := p.checkQuorum(); err != nil {
+		return nil, err
+	}
+	p.initial = p.initEvent()
+	if err := p.restore(); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
-func (p *Poll) Save() error {
-	f, err := os.OpenFile(p.filename, os.O_WRONLY|os.O_TRUNC, 0)
-	if err != nil {
+// restore rebuilds the poll state from its journal if there is one.
+func (p *Poll) restore() error {
+	events, err := p.Events()
+	if err != nil || len(events) == 0 {
 		return err
 	}
-	enc := yaml.NewEncoder(f)
-
-	err = enc.Encode(p)
-
-	err2 := enc.Close()
-	if err == nil {
-		err = err2
+	p.journaled = true
+	r, err := p.replay(events, time.Now())
+	if err != nil {
+		return err
 	}
+	p.State = r.State
+	p.Phase = r.Phase
+	p.PhaseTimes = r.PhaseTimes
+	p.Deadline = r.Deadline.In(p.Location())
+	p.TieOrder = r.TieOrder
+	p.Proposals = r.Proposals
+	return nil
+}
 
-	f.Sync()
-
-	err2 = f.Close()
-	if err == nil {
-		err = err2
-	}
-	return err
+// Name returns the poll name, the poll is stored under.
+func (p *Poll) Name() string {
+	return p.name
 }
 
-func (s State) check() error {
-	var sum uint
-	for _, v := range s.Votes {
-		sum += v.Count
-	}
-	if sum > s.MaxPoints() {
-		return fmt.Errorf("sum overflows %v", s.MaxPoints())
+// DisplayName returns the poll title, or the name if there is no title.
+func (p *Poll) DisplayName() string {
+	if p.Title != "" {
+		return p.Title
 	}
-	return nil
+	return p.name
 }
 
 func (p *Poll) add(old []Vote, vote Vote) []Vote {
 	n := make([]Vote, len(old), len(old)+1)
 	copy(n, old)
 	for i, v := range n {
-		if v.Short == vote.Short {
-			n[i].Count = vote.Count
+		if v.Variant == vote.Variant {
+			n[i] = vote
 			return n
 		}
 	}
@@ -181,25 +305,39 @@ func (p *Poll) add(old []Vote, vote Vote) []Vote {
 }
 
 func (p *Poll) Vote(name string, vote Vote) error {
+	return p.do(Event{User: name, Action: ActionVote, Vote: &vote})
+}
+
+func (p *Poll) vote(name string, vote Vote) error {
 	old := p.State[name]
-	if len(old.Votes) >= MaxVotes {
-		return fmt.Errorf("too many votes")
+	if old.vetoed(vote.Variant) {
+		return &RuleError{Rule: RuleVetoed}
 	}
-
 	n := p.add(old.Votes, vote)
 	old.Votes = n
-	if err := old.check(); err != nil {
+	if err := p.check(name, old); err != nil {
 		return err
 	}
 	p.State[name] = old
 	return nil
 }
 
-func (p *Poll) del(old []Vote, link string) ([]Vote, bool) {
+// check checks the ballot of name against the rules. Rules broken by
+// replayed events are only logged, the events were checked when made.
+func (p *Poll) check(name string, s State) error {
+	err := p.method().check(p, s)
+	if err != nil && p.replaying {
+		log.Printf("WARN: poll %v: ballot of %v breaks the current rules: %v", p.name, name, err)
+		return nil
+	}
+	return err
+}
+
+func (p *Poll) del(old []Vote, id string) ([]Vote, bool) {
 	n := old[:0]
 	var deleted bool
 	for _, v := range old {
-		if v.Short != link {
+		if v.Variant != id {
 			n = append(n, v)
 		} else {
 			deleted = true
@@ -208,26 +346,53 @@ func (p *Poll) del(old []Vote, link string) ([]Vote, bool) {
 	return n, deleted
 }
 
-func (p *Poll) DelVote(name string, sh string) error {
+func (p *Poll) DelVote(name string, id string) error {
+	return p.do(Event{User: name, Action: ActionUnvote, Variant: id})
+}
+
+func (p *Poll) delVote(name string, id string) error {
 	old := p.State[name]
-	n, ok := p.del(old.Votes, sh)
+	n, ok := p.del(old.Votes, id)
 	if !ok {
 		return fmt.Errorf("unknown vote")
 	}
+	if p.method().ranked() {
+		renumber(n)
+	}
 	old.Votes = n
 	p.State[name] = old
 	return nil
 }
 
+// Values returns values name may assign to the next variant under the poll method.
+func (p *Poll) Values(name string) []uint {
+	return p.method().values(p, name)
+}
+
+// Ranked reports whether votes of the poll are places instead of points.
+func (p *Poll) Ranked() bool {
+	return p.method().ranked()
+}
+
 func (p *Poll) getView(name string, notEmpty bool) []View {
 	res := make([]View, 0, len(p.Variants))
-	cnt := make(map[string]uint)
+	cnt := make(map[string]int)
 	for _, v := range p.State[name].Votes {
-		cnt[v.Short] = v.Count
+		cnt[v.Variant] = int(v.Count)
 	}
-	for i, v := range p.Variants {
-		if name != v.Author && (!notEmpty || cnt[v.Short()] > 0) {
-			res = append(res, View{v.Text, v.Short(), cnt[v.Short()], uint(i + 1)})
+	var pos uint
+	for _, i := range p.order(name) {
+		v := p.Variants[i]
+		if name == v.Author {
+			continue
+		}
+		pos++
+		if !notEmpty || cnt[v.ID] > 0 {
+			view := View{Text: v.Text, ID: v.ID, Count: cnt[v.ID], Index: uint(i + 1)}
+			if p.Shuffle {
+				view.Pos = pos
+			}
+			res = append(res, view)
 		}
 	}
 	return res
@@ -241,16 +406,29 @@ func (p *Poll) GetViewNotEmpty(name string) []View {
 	return p.getView(name, true)
 }
 
+// GetViewToVote returns variants name may pick next. Only the points method
+// allows to pick a variant again to change its points.
+func (p *Poll) GetViewToVote(name string) []View {
+	res := p.GetView(name)
+	n := res[:0]
+	for _, v := range res {
+		if (v.Count == 0 || p.Method == MethodPoints) && !p.State[name].vetoed(v.ID) {
+			n = append(n, v)
+		}
+	}
+	return n
+}
+
 func (p *Poll) Points(name string) (sum uint) {
 	for _, v := range p.GetViewNotEmpty(name) {
-		sum += v.Count
+		sum += uint(v.Count)
 	}
 	return sum
 }
 
 func (p *Poll) IsAdmin(name string) bool {
 	for _, admin := range p.admins {
-		if admin == name {
+		if admin == name || admin == p.usernames[name] && admin != "" {
 			return true
 		}
 	}
@@ -258,41 +436,59 @@ func (p *Poll) IsAdmin(name string) bool {
 }
 
 func (p *Poll) CanVote(name string) bool {
-	return !p.Closed && p.canVote(name)
+	return p.Phase == PhaseVoting && p.canVote(name)
 }
 
 func (p *Poll) canVote(name string) bool {
-	return p.Points(name) < p.State[name].MaxPoints() && len(p.GetViewNotEmpty(name)) < MaxVotes
+	return p.method().canVote(p, name)
 }
 
 func (p *Poll) CanUnvote(name string) bool {
-	return !p.Closed && len(p.GetViewNotEmpty(name)) > 0
+	return p.Phase == PhaseVoting && len(p.GetViewNotEmpty(name)) > 0
 }
 
 func (p *Poll) CanStop(name string) bool {
-	return !p.Closed && p.IsAdmin(name)
+	return p.Phase == PhaseVoting && p.IsAdmin(name) && !p.quorumHolds()
 }
 
 func (p *Poll) CanResume(name string) bool {
-	return p.Closed && p.IsAdmin(name)
+	return p.Phase == PhaseClosed && p.IsAdmin(name) && !p.Expired(time.Now())
 }
 
-func (p *Poll) NeedActivityCheck(name string) bool {
-	return false && p.Type == TypeBook && !p.State[name].ActivityChecked
+func (p *Poll) Stop(name string) error {
+	if !p.CanStop(name) {
+		return fmt.Errorf("you cant stop poll: %w", ErrForbidden)
+	}
+	return p.move(name, PhaseClosed)
+}
+
+func (p *Poll) Resume(name string) error {
+	if !p.CanResume(name) {
+		return fmt.Errorf("you cant resume poll: %w", ErrForbidden)
+	}
+	return p.move(name, PhaseVoting)
 }
 
 func (p *Poll) CheckUser(name string) error {
-	if _, ok := p.tgNotionMap[name]; !ok {
+	if _, ok := p.members[name]; !ok {
 		return fmt.Errorf("unknown user")
 	}
 	return nil
 }
 
+// Progress returns members who have not voted and how many members may
+// vote. A points ballot is done when the member can't give more points,
+// other ballots once anything is chosen.
 func (p *Poll) Progress() ([]string, int) {
 	var noVote []string
-	cnt := len(p.tgNotionMap)
-	for name := range p.tgNotionMap {
-		if p.canVote(name) {
+	cnt := len(p.members)
+	for name := range p.members {
+		s := p.State[name]
+		notVoted := !s.Disabled && len(s.Votes) == 0
+		if p.Method == MethodPoints {
+			notVoted = p.canVote(name)
+		}
+		if notVoted {
 			noVote = append(noVote, name)
 		}
 		if p.State[name].Disabled {
@@ -303,20 +499,30 @@ func (p *Poll) Progress() ([]string, int) {
 }
 
 func (p *Poll) Result(empty bool) []View {
+	if p.Method == MethodRanked {
+		res, stage := p.runoffResult(empty)
+		p.rank(res, stage)
+		return res
+	}
 	res := make([]View, 0, len(p.Variants))
-	cnt := make(map[string]uint)
+	cnt := make(map[string]int)
+	m := p.method()
 	for _, state := range p.State {
-		for _, vote := range state.Votes {
-			cnt[vote.Short] += vote.Count
+		for _, id := range state.Vetoes {
+			cnt[id] -= int(p.Rules.VetoPoints)
+		}
+		if !p.complete(state) {
+			continue
+		}
+		for id, c := range m.score(p, state) {
+			cnt[id] += int(c)
 		}
 	}
 	for i, v := range p.Variants {
-		if empty || cnt[v.Short()] > 0 {
-			res = append(res, View{v.Text, v.Short(), cnt[v.Short()], uint(i + 1)})
+		if empty || cnt[v.ID] != 0 {
+			res = append(res, View{Text: v.Text, ID: v.ID, Count: cnt[v.ID], Index: uint(i + 1)})
 		}
 	}
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].Count >= res[j].Count
-	})
+	p.rank(res, nil)
 	return res
 }
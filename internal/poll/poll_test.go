@@ -0,0 +1,114 @@
+package poll
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressRanked(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	p.Method = MethodRanked
+	mustVote(t, p, "alice", "a", 1)
+
+	noVote, cnt := p.Progress()
+	if cnt != 3 {
+		t.Errorf("members = %v, want 3", cnt)
+	}
+	for _, name := range noVote {
+		if name == "alice" {
+			t.Errorf("alice with a first choice is counted as not voted: %v", noVote)
+		}
+	}
+	if len(noVote) != 2 {
+		t.Errorf("not voted = %v, want bob and carol", noVote)
+	}
+}
+
+func TestResultRowsMatchResult(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	p.Rules.MinSpend = 5
+	p.Rules.Vetoes = 1
+	mustVote(t, p, "alice", "a", 2)
+	if err := p.VetoFor("alice", "alice", "b"); err != nil {
+		t.Fatal(err)
+	}
+	mustVote(t, p, "bob", "b", 5)
+	mustVote(t, p, "carol", "c", 6)
+
+	sum := make(map[string]int)
+	for _, r := range p.resultRows() {
+		sum[r.variant] += r.count
+	}
+	want := map[string]int{"b": 5 - int(p.Rules.VetoPoints), "c": 6}
+	for _, v := range p.Result(true) {
+		if sum[v.ID] != v.Count {
+			t.Errorf("rows of %v add up to %v, Result has %v", v.ID, sum[v.ID], v.Count)
+		}
+		if v.Count != want[v.ID] {
+			t.Errorf("Result of %v = %v, want %v", v.ID, v.Count, want[v.ID])
+		}
+	}
+}
+
+func TestRenameMember(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Members[0].ID = 1
+	cfg.Index()
+	p := testPoll(t, cfg, "p")
+
+	if key, _ := cfg.Bind(1, "alice_new"); key != "1" {
+		t.Fatalf("key = %v, want 1", key)
+	}
+	if got := p.Mention("1"); got != "@alice_new" {
+		t.Errorf("mention after rename = %v, want @alice_new", got)
+	}
+	if !p.IsAdmin("1") {
+		t.Errorf("renamed admin is not an admin")
+	}
+	if p.IsAdmin("alice") {
+		t.Errorf("old username is still an admin")
+	}
+}
+
+func TestOpenPollRequiredMembers(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	p.Quorum.Required = []string{"alice", "dave"}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPoll(cfg, "p"); err == nil {
+		t.Errorf("poll requiring an unknown member is opened")
+	}
+
+	p.Quorum.Required = []string{"alice"}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPoll(cfg, "p"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCanExtendPhases(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	p.Deadline = time.Now().Add(time.Hour)
+	for _, tt := range []struct {
+		phase string
+		want  bool
+	}{
+		{PhaseDraft, false},
+		{PhaseNomination, false},
+		{PhaseVoting, true},
+		{PhaseClosed, true},
+		{PhasePublished, false},
+	} {
+		p.Phase = tt.phase
+		if got := p.CanExtend("alice"); got != tt.want {
+			t.Errorf("CanExtend in %v = %v, want %v", tt.phase, got, tt.want)
+		}
+	}
+}
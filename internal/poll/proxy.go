@@ -0,0 +1,75 @@
+package poll
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CanSetProxy reports whether name may choose a proxy of member.
+func (p *Poll) CanSetProxy(name, member string) bool {
+	return !p.Closed() && (name == member || p.IsAdmin(name))
+}
+
+// SetProxy lets proxy vote for member, an empty proxy revokes it.
+func (p *Poll) SetProxy(name, member, proxy string) error {
+	if !p.CanSetProxy(name, member) {
+		return fmt.Errorf("you cant set proxy of %v: %w", member, ErrForbidden)
+	}
+	if _, ok := p.members[member]; !ok {
+		return fmt.Errorf("unknown member: %v", member)
+	}
+	if proxy != "" {
+		if _, ok := p.members[proxy]; !ok || proxy == member {
+			return fmt.Errorf("bad proxy: %v", proxy)
+		}
+	}
+	return p.do(Event{User: name, Action: ActionProxy, Member: by(name, member), Proxy: proxy})
+}
+
+func (p *Poll) setProxy(member, proxy string) {
+	s := p.State[member]
+	s.Proxy = proxy
+	p.State[member] = s
+}
+
+// Principals returns members who chose name as their proxy, sorted.
+func (p *Poll) Principals(name string) []string {
+	var res []string
+	for member, s := range p.State {
+		if s.Proxy == name && name != "" {
+			res = append(res, member)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// canActFor reports whether name may cast the ballot of member.
+func (p *Poll) canActFor(name, member string) bool {
+	return name == member || p.State[member].Proxy == name
+}
+
+// VoteFor casts a vote of member, name is either member or their proxy.
+func (p *Poll) VoteFor(name, member string, vote Vote) error {
+	if !p.canActFor(name, member) {
+		return fmt.Errorf("%v is not a proxy of %v: %w", name, member, ErrForbidden)
+	}
+	return p.do(Event{User: name, Action: ActionVote, Member: by(name, member), Vote: &vote})
+}
+
+// DelVoteFor deletes a vote of member, name is either member or their proxy.
+func (p *Poll) DelVoteFor(name, member string, id string) error {
+	if !p.canActFor(name, member) {
+		return fmt.Errorf("%v is not a proxy of %v: %w", name, member, ErrForbidden)
+	}
+	return p.do(Event{User: name, Action: ActionUnvote, Member: by(name, member), Variant: id})
+}
+
+// by returns the member to record in an event made by name, empty if
+// name acts for themselves.
+func by(name, member string) string {
+	if name == member {
+		return ""
+	}
+	return member
+}
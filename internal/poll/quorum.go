@@ -0,0 +1,74 @@
+package poll
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Quorum is a turnout a poll needs for its result to count.
+type Quorum struct {
+	// Turnout is the least percent of members who must vote.
+	Turnout uint `yaml:"turnout,omitempty"`
+	// Required members must have a counted ballot, one cast by their proxy
+	// counts too.
+	Required []string `yaml:"required,omitempty"`
+	// Strict quorum keeps the poll open until it is met, otherwise admins
+	// are only warned.
+	Strict bool `yaml:"strict,omitempty"`
+}
+
+// checkQuorum fails on required members who are not members, a typo would
+// keep a strict quorum from ever being met.
+func (p *Poll) checkQuorum() error {
+	for _, name := range p.Quorum.Required {
+		if _, ok := p.members[name]; !ok {
+			return fmt.Errorf("unknown required member: %v", name)
+		}
+	}
+	return nil
+}
+
+// Voted reports whether name has a counted ballot, cast by a proxy too.
+func (p *Poll) Voted(name string) bool {
+	s := p.State[name]
+	return !s.Disabled && len(s.Votes) > 0 && p.complete(s)
+}
+
+// QuorumLeft returns how many more members must vote to reach the turnout
+// and required members who have not voted yet.
+func (p *Poll) QuorumLeft() (int, []string) {
+	var voted, total int
+	for name := range p.members {
+		if p.State[name].Disabled {
+			continue
+		}
+		total++
+		if p.Voted(name) {
+			voted++
+		}
+	}
+	// the least number of voters making up the turnout, rounded up.
+	need := (total*int(p.Quorum.Turnout) + 99) / 100
+	var missing []string
+	for _, name := range p.Quorum.Required {
+		if !p.Voted(name) && !p.State[name].Disabled {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	if need < voted {
+		need = voted
+	}
+	return need - voted, missing
+}
+
+// HasQuorum reports whether the poll reached its quorum.
+func (p *Poll) HasQuorum() bool {
+	need, missing := p.QuorumLeft()
+	return need == 0 && len(missing) == 0
+}
+
+// quorumHolds reports whether a lack of quorum keeps the poll open.
+func (p *Poll) quorumHolds() bool {
+	return p.Quorum.Strict && !p.HasQuorum()
+}
@@ -0,0 +1,180 @@
+package poll
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// TieBreakNone leaves tied variants on a shared place.
+	TieBreakNone = "none"
+	// TieBreakVoters prefers a variant more members voted for.
+	TieBreakVoters = "voters"
+	// TieBreakFirst prefers a variant more members put first.
+	TieBreakFirst = "first"
+	// TieBreakEarliest prefers a variant proposed earlier.
+	TieBreakEarliest = "earliest"
+	// TieBreakAdmin uses an order chosen by an admin, see Poll.TieOrder.
+	TieBreakAdmin = "admin"
+	// TieBreakLottery draws the order with a random generator seeded by Poll.Seed.
+	TieBreakLottery = "lottery"
+)
+
+var tieBreaks = map[string]bool{
+	TieBreakNone:     true,
+	TieBreakVoters:   true,
+	TieBreakFirst:    true,
+	TieBreakEarliest: true,
+	TieBreakAdmin:    true,
+	TieBreakLottery:  true,
+}
+
+func checkTieBreak(name string) error {
+	if !tieBreaks[name] {
+		return fmt.Errorf("unknown tie break: %v", name)
+	}
+	return nil
+}
+
+// tieKeys returns a tie-break key of every variant, the bigger the better.
+// Equal keys mean the tie stays.
+func (p *Poll) tieKeys() map[string]int {
+	keys := make(map[string]int, len(p.Variants))
+	switch p.TieBreak {
+	case TieBreakVoters:
+		for _, state := range p.State {
+			for _, v := range state.Votes {
+				if v.Count > 0 {
+					keys[v.Variant]++
+				}
+			}
+		}
+	case TieBreakFirst:
+		for _, state := range p.State {
+			for _, id := range p.firstChoices(state) {
+				keys[id]++
+			}
+		}
+	case TieBreakEarliest:
+		for i, v := range p.Variants {
+			keys[v.ID] = len(p.Variants) - i
+		}
+	case TieBreakAdmin:
+		for i, id := range p.TieOrder {
+			keys[id] = len(p.TieOrder) - i
+		}
+	case TieBreakLottery:
+		perm := rand.New(rand.NewSource(p.Seed)).Perm(len(p.Variants))
+		for i, v := range p.Variants {
+			keys[v.ID] = perm[i]
+		}
+	}
+	return keys
+}
+
+// firstChoices returns variants the ballot prefers the most: the first
+// place of a ranked ballot or the variants with the most points otherwise.
+func (p *Poll) firstChoices(s State) []string {
+	var best uint
+	var res []string
+	for _, v := range s.Votes {
+		if v.Count == 0 {
+			continue
+		}
+		better := v.Count > best
+		if p.Ranked() {
+			better = best == 0 || v.Count < best
+		}
+		if better {
+			best = v.Count
+			res = res[:0]
+		}
+		if v.Count == best {
+			res = append(res, v.Variant)
+		}
+	}
+	return res
+}
+
+// rank orders views by stage, count and tie-break keys, the best first,
+// and fills View.Place. Views equal in all of them share a place.
+func (p *Poll) rank(res []View, stage map[string]int) {
+	keys := p.tieKeys()
+	less := func(a, b View) bool {
+		if stage[a.ID] != stage[b.ID] {
+			return stage[a.ID] > stage[b.ID]
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return keys[a.ID] > keys[b.ID]
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if less(res[i], res[j]) || less(res[j], res[i]) {
+			return less(res[i], res[j])
+		}
+		return res[i].Index < res[j].Index
+	})
+	for i := range res {
+		if i > 0 && !less(res[i-1], res[i]) {
+			res[i].Place = res[i-1].Place
+		} else {
+			res[i].Place = uint(i + 1)
+		}
+	}
+}
+
+// Ties groups views of a ranked result sharing a place.
+func Ties(vs []View) [][]View {
+	var res [][]View
+	for i := 0; i < len(vs); {
+		j := i + 1
+		for j < len(vs) && vs[j].Place == vs[i].Place {
+			j++
+		}
+		if j-i > 1 {
+			res = append(res, vs[i:j])
+		}
+		i = j
+	}
+	return res
+}
+
+// TopTie returns variants sharing the first place, nil if the winner is clear.
+func (p *Poll) TopTie() []View {
+	res := p.Result(false)
+	if ties := Ties(res); len(ties) > 0 && ties[0][0].Place == 1 {
+		return ties[0]
+	}
+	return nil
+}
+
+// CanBreakTie reports whether name should decide the order of the tied top.
+func (p *Poll) CanBreakTie(name string) bool {
+	return p.Closed() && p.TieBreak == TieBreakAdmin && p.IsAdmin(name) && len(p.TopTie()) > 0
+}
+
+// BreakTie puts the variant ahead of every other one in Poll.TieOrder.
+func (p *Poll) BreakTie(name string, id string) error {
+	var found bool
+	for _, v := range p.TopTie() {
+		if v.ID == id {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("variant %v is not tied", id)
+	}
+	return p.do(Event{User: name, Action: ActionTie, Variant: id})
+}
+
+func (p *Poll) breakTie(id string) {
+	order := []string{id}
+	for _, s := range p.TieOrder {
+		if s != id {
+			order = append(order, s)
+		}
+	}
+	p.TieOrder = order
+}
@@ -0,0 +1,113 @@
+package poll
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// tiedViews returns a and b tied at the top and c behind them.
+func tiedViews() []View {
+	return []View{
+		{ID: "c", Count: 1, Index: 3},
+		{ID: "b", Count: 5, Index: 2},
+		{ID: "a", Count: 5, Index: 1},
+	}
+}
+
+func places(vs []View) []string {
+	res := make([]string, len(vs))
+	for i, v := range vs {
+		res[i] = fmt.Sprintf("%v:%v", v.Place, v.ID)
+	}
+	return res
+}
+
+func TestRank(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	// a has the most voters, b the most first choices.
+	p.State = map[string]State{
+		"alice": {Votes: []Vote{{Variant: "b", Count: 3}, {Variant: "a", Count: 1}}},
+		"bob":   {Votes: []Vote{{Variant: "b", Count: 2}, {Variant: "a", Count: 1}}},
+		"carol": {Votes: []Vote{{Variant: "a", Count: 4}}},
+	}
+	for _, tt := range []struct {
+		tieBreak string
+		tieOrder []string
+		want     []string
+	}{
+		{TieBreakNone, nil, []string{"1:a", "1:b", "3:c"}},
+		{TieBreakVoters, nil, []string{"1:a", "2:b", "3:c"}},
+		{TieBreakFirst, nil, []string{"1:b", "2:a", "3:c"}},
+		{TieBreakEarliest, nil, []string{"1:a", "2:b", "3:c"}},
+		{TieBreakAdmin, []string{"b", "a"}, []string{"1:b", "2:a", "3:c"}},
+	} {
+		p.TieBreak = tt.tieBreak
+		p.TieOrder = tt.tieOrder
+		vs := tiedViews()
+		p.rank(vs, nil)
+		if got := places(vs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: places = %v, want %v", tt.tieBreak, got, tt.want)
+		}
+	}
+}
+
+func TestRankLottery(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	p.TieBreak = TieBreakLottery
+	p.Seed = 42
+
+	first := tiedViews()
+	p.rank(first, nil)
+	if first[0].Place != 1 || first[1].Place != 2 {
+		t.Errorf("lottery left a tie: %v", places(first))
+	}
+	for i := 0; i < 3; i++ {
+		vs := tiedViews()
+		p.rank(vs, nil)
+		if !reflect.DeepEqual(places(vs), places(first)) {
+			t.Errorf("order with the same seed = %v, was %v", places(vs), places(first))
+		}
+	}
+}
+
+func TestTies(t *testing.T) {
+	vs := []View{{ID: "a", Place: 1}, {ID: "b", Place: 1}, {ID: "c", Place: 3}, {ID: "d", Place: 4}, {ID: "e", Place: 4}}
+	var got [][]string
+	for _, tie := range Ties(vs) {
+		var ids []string
+		for _, v := range tie {
+			ids = append(ids, v.ID)
+		}
+		got = append(got, ids)
+	}
+	want := [][]string{{"a", "b"}, {"d", "e"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ties = %v, want %v", got, want)
+	}
+	if ties := Ties(vs[2:4]); len(ties) != 0 {
+		t.Errorf("ties of distinct places = %v", ties)
+	}
+}
+
+func TestTopTie(t *testing.T) {
+	cfg := testConfig(t)
+	p := testPoll(t, cfg, "p")
+	mustVote(t, p, "alice", "a", 5)
+	mustVote(t, p, "bob", "b", 5)
+	mustVote(t, p, "carol", "c", 1)
+
+	var ids []string
+	for _, v := range p.TopTie() {
+		ids = append(ids, v.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("top tie = %v, want a and b", ids)
+	}
+	p.TieBreak = TieBreakEarliest
+	if tie := p.TopTie(); tie != nil {
+		t.Errorf("top tie after the tie break = %v, want none", tie)
+	}
+}
@@ -0,0 +1,83 @@
+package poll
+
+// Default rules of a poll.
+const (
+	DefaultBudget         = 10
+	DefaultMaxVariants    = 3
+	DefaultInactiveBudget = 7
+	DefaultMaxProposals   = 1
+	DefaultVetoPoints     = 3
+)
+
+// Rules limit ballots of the points method and proposals. Zero fields take
+// defaults, MaxPerVariant defaults to the budget.
+type Rules struct {
+	// Budget is the number of points every member spreads.
+	Budget uint `yaml:"budget,omitempty"`
+	// MaxVariants is the most variants a member may give points to.
+	MaxVariants uint `yaml:"max_variants,omitempty"`
+	// MaxPerVariant is the most points a member may give to one variant.
+	MaxPerVariant uint `yaml:"max_per_variant,omitempty"`
+	// MinSpend is the least points a ballot needs to be counted.
+	MinSpend uint `yaml:"min_spend,omitempty"`
+	// InactiveBudget replaces the budget of members who were not active.
+	InactiveBudget uint `yaml:"inactive_budget,omitempty"`
+	// MaxProposals is the most books a member may nominate.
+	MaxProposals uint `yaml:"max_proposals,omitempty"`
+	// Vetoes is a number of vetoes every member has, zero disables them.
+	Vetoes uint `yaml:"vetoes,omitempty"`
+	// VetoPoints are taken from a variant by every veto.
+	VetoPoints uint `yaml:"veto_points,omitempty"`
+}
+
+func (r *Rules) setDefaults() {
+	if r.Budget == 0 {
+		r.Budget = DefaultBudget
+	}
+	if r.MaxVariants == 0 {
+		r.MaxVariants = DefaultMaxVariants
+	}
+	if r.MaxPerVariant == 0 {
+		r.MaxPerVariant = r.Budget
+	}
+	if r.InactiveBudget == 0 {
+		r.InactiveBudget = DefaultInactiveBudget
+	}
+	if r.MaxProposals == 0 {
+		r.MaxProposals = DefaultMaxProposals
+	}
+	if r.VetoPoints == 0 {
+		r.VetoPoints = DefaultVetoPoints
+	}
+}
+
+// Budget returns the number of points name may spread.
+func (p *Poll) Budget(name string) uint {
+	return p.Rules.budget(p.State[name])
+}
+
+func (r Rules) budget(s State) uint {
+	if s.Disabled {
+		return 0
+	}
+	if !s.ActivityChecked || s.Activity {
+		return r.Budget
+	}
+	return r.InactiveBudget
+}
+
+// Complete reports whether the ballot of name is counted in the result.
+func (p *Poll) Complete(name string) bool {
+	return p.complete(p.State[name])
+}
+
+func (p *Poll) complete(s State) bool {
+	if p.Method != MethodPoints {
+		return true
+	}
+	var sum uint
+	for _, v := range s.Votes {
+		sum += v.Count
+	}
+	return sum >= p.Rules.MinSpend
+}
@@ -0,0 +1,188 @@
+package poll
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Round is a single round of instant-runoff counting.
+type Round struct {
+	// Counts holds variants still running, the most voted first.
+	Counts []View
+	// Exhausted is a number of ballots without running variants left.
+	Exhausted uint
+	// Eliminated holds variants dropped after this round.
+	Eliminated []View
+	// Transfers tells where ballots of eliminated variants moved to.
+	// Ballots with no further choice are counted under an empty key.
+	Transfers map[string]uint
+
+	texts map[string]string
+}
+
+func (r Round) String() string {
+	b := new(strings.Builder)
+	b.WriteString(strings.Join(convViews(r.Counts), "\n"))
+	if r.Exhausted > 0 {
+		b.WriteString(fmt.Sprintf("\nБез голоса: %v", r.Exhausted))
+	}
+	if len(r.Eliminated) == 0 {
+		return b.String()
+	}
+	names := make([]string, len(r.Eliminated))
+	for i, v := range r.Eliminated {
+		names[i] = v.Text
+	}
+	b.WriteString(fmt.Sprintf("\nВыбывает: %v", strings.Join(names, ", ")))
+	ids := make([]string, 0, len(r.Transfers))
+	for id := range r.Transfers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		to := r.texts[id]
+		if id == "" {
+			to = "никому"
+		}
+		b.WriteString(fmt.Sprintf("\n  → %v: %v", to, r.Transfers[id]))
+	}
+	return b.String()
+}
+
+func convViews(vs []View) []string {
+	res := make([]string, len(vs))
+	for i, v := range vs {
+		res[i] = v.String()
+	}
+	return res
+}
+
+// ballots returns preferences of every member, the first choice first.
+func (p *Poll) ballots() [][]string {
+	var res [][]string
+	for _, state := range p.State {
+		if len(state.Votes) == 0 {
+			continue
+		}
+		votes := make([]Vote, len(state.Votes))
+		copy(votes, state.Votes)
+		sort.Slice(votes, func(i, j int) bool {
+			return votes[i].Count < votes[j].Count
+		})
+		b := make([]string, len(votes))
+		for i, v := range votes {
+			b[i] = v.Variant
+		}
+		res = append(res, b)
+	}
+	return res
+}
+
+func firstRunning(ballot []string, running map[string]bool) string {
+	for _, id := range ballot {
+		if running[id] {
+			return id
+		}
+	}
+	return ""
+}
+
+// Runoff counts ranked ballots by instant runoff. Every round the variants
+// with the fewest votes are eliminated and their ballots move to the next
+// choice, until some variant gets a majority or nobody can be eliminated.
+func (p *Poll) Runoff() []Round {
+	running := make(map[string]bool, len(p.Variants))
+	texts := make(map[string]string, len(p.Variants))
+	index := make(map[string]uint, len(p.Variants))
+	for i, v := range p.Variants {
+		running[v.ID] = true
+		texts[v.ID] = v.Text
+		index[v.ID] = uint(i + 1)
+	}
+	ballots := p.ballots()
+	keys := p.tieKeys()
+
+	var rounds []Round
+	for len(running) > 0 {
+		r := Round{texts: texts}
+		cnt := make(map[string]uint, len(running))
+		var total uint
+		for _, b := range ballots {
+			if id := firstRunning(b, running); id != "" {
+				cnt[id]++
+				total++
+			} else {
+				r.Exhausted++
+			}
+		}
+		for id := range running {
+			r.Counts = append(r.Counts, View{Text: texts[id], ID: id, Count: int(cnt[id]), Index: index[id]})
+		}
+		sort.Slice(r.Counts, func(i, j int) bool {
+			if r.Counts[i].Count != r.Counts[j].Count {
+				return r.Counts[i].Count > r.Counts[j].Count
+			}
+			if keys[r.Counts[i].ID] != keys[r.Counts[j].ID] {
+				return keys[r.Counts[i].ID] > keys[r.Counts[j].ID]
+			}
+			return r.Counts[i].Index < r.Counts[j].Index
+		})
+
+		top, bottom := r.Counts[0].Count, r.Counts[len(r.Counts)-1].Count
+		if total == 0 || uint(top)*2 > total || top == bottom {
+			rounds = append(rounds, r)
+			break
+		}
+
+		// Variants nobody voted for go all at once, otherwise a tie at the
+		// bottom drops the variant losing the tie break, the latest proposed
+		// one if the tie break can't tell.
+		eliminated := make(map[string]bool)
+		for i := len(r.Counts) - 1; i >= 0 && r.Counts[i].Count == bottom; i-- {
+			eliminated[r.Counts[i].ID] = true
+			r.Eliminated = append(r.Eliminated, r.Counts[i])
+			if bottom > 0 {
+				break
+			}
+		}
+		for id := range eliminated {
+			delete(running, id)
+		}
+		r.Transfers = make(map[string]uint)
+		for _, b := range ballots {
+			for _, id := range b {
+				if eliminated[id] {
+					r.Transfers[firstRunning(b, running)]++
+					break
+				}
+				if running[id] {
+					break
+				}
+			}
+		}
+		rounds = append(rounds, r)
+	}
+	return rounds
+}
+
+// runoffResult returns variants with their counts in the round they were
+// eliminated in, and the stage of each: the later eliminated, the higher.
+func (p *Poll) runoffResult(empty bool) ([]View, map[string]int) {
+	rounds := p.Runoff()
+	res := make([]View, 0, len(p.Variants))
+	stage := make(map[string]int, len(p.Variants))
+	for i, r := range rounds {
+		vs := r.Eliminated
+		if i == len(rounds)-1 {
+			vs = r.Counts
+		}
+		for _, v := range vs {
+			stage[v.ID] = i
+			if empty || v.Count > 0 {
+				res = append(res, v)
+			}
+		}
+	}
+	return res, stage
+}
@@ -0,0 +1,149 @@
+package poll
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// rankedPoll returns a ranked poll of variants with the ids, every ballot
+// lists its choices the first first.
+func rankedPoll(ids []string, ballots [][]string) *Poll {
+	p := &Poll{Method: MethodRanked, TieBreak: TieBreakNone, State: make(map[string]State)}
+	for _, id := range ids {
+		p.Variants = append(p.Variants, Variant{ID: id, Text: id})
+	}
+	for i, b := range ballots {
+		var s State
+		for place, id := range b {
+			s.Votes = append(s.Votes, Vote{Variant: id, Count: uint(place + 1)})
+		}
+		p.State[fmt.Sprintf("m%v", i)] = s
+	}
+	return p
+}
+
+type wantRound struct {
+	counts     map[string]int
+	exhausted  uint
+	eliminated []string
+	transfers  map[string]uint
+}
+
+func TestRunoff(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		ids      []string
+		ballots  [][]string
+		tieBreak string
+		tieOrder []string
+		want     []wantRound
+	}{
+		{
+			name:    "majority",
+			ids:     []string{"a", "b", "c"},
+			ballots: [][]string{{"a"}, {"a", "b"}, {"b"}},
+			want: []wantRound{
+				{counts: map[string]int{"a": 2, "b": 1, "c": 0}},
+			},
+		},
+		{
+			name:    "zero votes go together",
+			ids:     []string{"a", "b", "c", "d"},
+			ballots: [][]string{{"a", "b"}, {"b", "a"}, {"a"}, {"b"}},
+			want: []wantRound{
+				{counts: map[string]int{"a": 2, "b": 2, "c": 0, "d": 0}, eliminated: []string{"c", "d"}, transfers: map[string]uint{}},
+				{counts: map[string]int{"a": 2, "b": 2}},
+			},
+		},
+		{
+			name:    "transfer",
+			ids:     []string{"a", "b", "c"},
+			ballots: [][]string{{"a"}, {"a"}, {"b"}, {"b", "a"}, {"c", "b"}},
+			want: []wantRound{
+				{counts: map[string]int{"a": 2, "b": 2, "c": 1}, eliminated: []string{"c"}, transfers: map[string]uint{"b": 1}},
+				{counts: map[string]int{"a": 2, "b": 3}},
+			},
+		},
+		{
+			name:    "exhausted",
+			ids:     []string{"a", "b", "c"},
+			ballots: [][]string{{"a"}, {"a"}, {"b"}, {"b", "a"}, {"c"}},
+			want: []wantRound{
+				{counts: map[string]int{"a": 2, "b": 2, "c": 1}, eliminated: []string{"c"}, transfers: map[string]uint{"": 1}},
+				{counts: map[string]int{"a": 2, "b": 2}, exhausted: 1},
+			},
+		},
+		{
+			name:    "bottom tie drops the latest",
+			ids:     []string{"a", "b", "c"},
+			ballots: [][]string{{"a"}, {"a"}, {"b", "c"}, {"c", "a"}},
+			want: []wantRound{
+				{counts: map[string]int{"a": 2, "b": 1, "c": 1}, eliminated: []string{"c"}, transfers: map[string]uint{"a": 1}},
+				{counts: map[string]int{"a": 3, "b": 1}},
+			},
+		},
+		{
+			name:     "bottom tie broken by admin",
+			ids:      []string{"a", "b", "c"},
+			ballots:  [][]string{{"a"}, {"a"}, {"b", "c"}, {"c", "a"}},
+			tieBreak: TieBreakAdmin,
+			tieOrder: []string{"c", "b"},
+			want: []wantRound{
+				{counts: map[string]int{"a": 2, "b": 1, "c": 1}, eliminated: []string{"b"}, transfers: map[string]uint{"c": 1}},
+				{counts: map[string]int{"a": 2, "c": 2}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := rankedPoll(tt.ids, tt.ballots)
+			if tt.tieBreak != "" {
+				p.TieBreak = tt.tieBreak
+			}
+			p.TieOrder = tt.tieOrder
+			rounds := p.Runoff()
+			if len(rounds) != len(tt.want) {
+				t.Fatalf("rounds = %v, want %v", len(rounds), len(tt.want))
+			}
+			for i, r := range rounds {
+				w := tt.want[i]
+				counts := make(map[string]int)
+				for _, v := range r.Counts {
+					counts[v.ID] = v.Count
+				}
+				if !reflect.DeepEqual(counts, w.counts) {
+					t.Errorf("round %v counts = %v, want %v", i+1, counts, w.counts)
+				}
+				if r.Exhausted != w.exhausted {
+					t.Errorf("round %v exhausted = %v, want %v", i+1, r.Exhausted, w.exhausted)
+				}
+				var eliminated []string
+				for _, v := range r.Eliminated {
+					eliminated = append(eliminated, v.ID)
+				}
+				sort.Strings(eliminated)
+				if !reflect.DeepEqual(eliminated, w.eliminated) {
+					t.Errorf("round %v eliminated = %v, want %v", i+1, eliminated, w.eliminated)
+				}
+				if !reflect.DeepEqual(r.Transfers, w.transfers) {
+					t.Errorf("round %v transfers = %v, want %v", i+1, r.Transfers, w.transfers)
+				}
+			}
+		})
+	}
+}
+
+func TestRunoffResultStages(t *testing.T) {
+	p := rankedPoll([]string{"a", "b", "c"}, [][]string{{"a"}, {"a"}, {"b"}, {"b", "a"}, {"c", "b"}})
+	res := p.Result(true)
+	var got []string
+	for _, v := range res {
+		got = append(got, fmt.Sprintf("%v:%v:%v", v.Place, v.ID, v.Count))
+	}
+	// b wins the last round, c is out after the first one with its count there.
+	want := []string{"1:b:3", "2:a:2", "3:c:1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("result = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package poll
+
+import (
+	"fmt"
+	"time"
+)
+
+// Top returns the best n variants of the result. Variants tied with the
+// last one are returned as well, so a runoff never drops one of them at random.
+func (p *Poll) Top(n int) []View {
+	res := p.Result(false)
+	if n <= 0 {
+		return nil
+	}
+	if len(res) <= n {
+		return res
+	}
+	last := res[n-1].Place
+	for n < len(res) && res[n].Place == last {
+		n++
+	}
+	return res[:n]
+}
+
+// RunoffName returns a name of a runoff poll of the poll.
+func (p *Poll) RunoffName() string {
+	return p.name + "_2"
+}
+
+func (p *Poll) CanRunoff(name string) bool {
+	return p.Closed() && p.IsAdmin(name) && p.Next == "" && len(p.Result(false)) > 2
+}
+
+// CreateRunoff creates a poll from the top variants of the closed poll.
+// Variant IDs and authors are kept, so Notion relations and self-vote
+// exclusion still work, and both polls are linked to each other.
+func (p *Poll) CreateRunoff(name string, top int) (*Poll, error) {
+	if !p.Closed() {
+		return nil, fmt.Errorf("poll is not closed")
+	}
+	if p.Next != "" {
+		return nil, fmt.Errorf("poll already has runoff: %v", p.Next)
+	}
+	views := p.Top(top)
+	if len(views) < 2 {
+		return nil, fmt.Errorf("not enough variants for runoff: %v", len(views))
+	}
+
+	r, err := createPoll(p.store, name)
+	if err != nil {
+		return nil, err
+	}
+	r.setPhase(PhaseVoting, time.Now())
+	r.Type = p.Type
+	r.Method = p.Method
+	r.Rules = p.Rules
+	r.Secrecy = p.Secrecy
+	r.Anonymous = p.Anonymous
+	r.Shuffle = p.Shuffle
+	r.Quorum = p.Quorum
+	r.ResultDB = p.ResultDB
+	r.TieBreak = p.TieBreak
+	r.Seed = time.Now().UnixNano()
+	r.Timezone = p.Timezone
+	r.Parent = p.name
+	r.admins = p.admins
+	r.members = p.members
+	r.usernames = p.usernames
+	for _, v := range views {
+		r.Variants = append(r.Variants, p.Variants[v.Index-1])
+	}
+	for name, s := range p.State {
+		r.State[name] = State{
+			Disabled:        s.Disabled,
+			ActivityChecked: s.ActivityChecked,
+			Activity:        s.Activity,
+			Proxy:           s.Proxy,
+		}
+	}
+	if err := r.Save(); err != nil {
+		r.remove()
+		return nil, err
+	}
+
+	p.Next = name
+	if err := p.Save(); err != nil {
+		r.remove()
+		p.Next = ""
+		return nil, err
+	}
+	return r, nil
+}
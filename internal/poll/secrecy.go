@@ -0,0 +1,43 @@
+package poll
+
+import "fmt"
+
+const (
+	// SecrecyOpen shows live results to every member.
+	SecrecyOpen = "open"
+	// SecrecyAdmins shows live results to admins only.
+	SecrecyAdmins = "admins"
+	// SecrecySealed hides results from everyone until the poll is closed.
+	SecrecySealed = "sealed"
+)
+
+var secrecies = map[string]bool{
+	SecrecyOpen:   true,
+	SecrecyAdmins: true,
+	SecrecySealed: true,
+}
+
+func checkSecrecy(name string) error {
+	if !secrecies[name] {
+		return fmt.Errorf("unknown secrecy: %v", name)
+	}
+	return nil
+}
+
+// CanSeeResult reports whether name may see the result of the poll.
+func (p *Poll) CanSeeResult(name string) bool {
+	switch {
+	case p.Closed():
+		return true
+	case p.Secrecy == SecrecyOpen:
+		return true
+	case p.Secrecy == SecrecyAdmins:
+		return p.IsAdmin(name)
+	}
+	return false
+}
+
+// Sealed reports whether nobody may see the result or ballots yet.
+func (p *Poll) Sealed() bool {
+	return p.Secrecy == SecrecySealed && !p.Closed()
+}
@@ -0,0 +1,122 @@
+package poll
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/molchalin/mitkabot/internal/config"
+)
+
+const (
+	// StorageYAML keeps every poll in its own file.
+	StorageYAML = "yaml"
+	// StorageBolt keeps polls, journals and sessions in one embedded database.
+	StorageBolt = "bolt"
+)
+
+// ErrConflict is returned by Save if the poll was saved by someone else
+// since it was read. The poll should be read again.
+var ErrConflict = errors.New("poll was changed by someone else")
+
+// ErrExists is returned by Create if a poll with the name is stored already.
+var ErrExists = errors.New("poll already exists")
+
+// Storage keeps polls, their journals, dispatcher sessions and member bindings.
+// Polls are stored encoded, so a storage doesn't depend on the Poll layout.
+type Storage interface {
+	// Create reserves the name for a new poll, it fails with ErrExists if
+	// the name is taken.
+	Create(name string) error
+	Remove(name string) error
+	// List returns names of all stored polls.
+	List() ([]string, error)
+	Load(name string) ([]byte, error)
+	// Save replaces the poll and appends events to its journal at once.
+	// It fails with ErrConflict unless the stored poll has the version.
+	Save(name string, version uint64, data []byte, events []Event) error
+	Events(name string) ([]Event, error)
+
+	// Session returns the dispatcher session stored under the key, nil if there is none.
+	Session(key string) ([]byte, error)
+	// SaveSession stores the session, nil data deletes it.
+	SaveSession(key string, data []byte) error
+	// Sessions returns every stored session.
+	Sessions() (map[string][]byte, error)
+
+	// Bindings returns user IDs members without them in the config got
+	// when they first talked to the bot, by their usernames in the config.
+	Bindings() (map[string]int64, error)
+	SaveBinding(username string, id int64) error
+}
+
+// NewStorage returns the storage chosen in the config, YAML files in etc by default.
+func NewStorage(cfg *config.Config) (Storage, error) {
+	switch cfg.Storage {
+	case "", StorageYAML:
+		dir := cfg.StoragePath
+		if dir == "" {
+			dir = "etc"
+		}
+		return yamlStorage{dir: dir}, nil
+	case StorageBolt:
+		if cfg.StoragePath == "" {
+			return nil, fmt.Errorf("storage_path required for %v storage", cfg.Storage)
+		}
+		return boltStorage{path: cfg.StoragePath}, nil
+	}
+	return nil, fmt.Errorf("unknown storage: %v", cfg.Storage)
+}
+
+// LoadBindings gives members of the config user IDs they got from the bot.
+func LoadBindings(cfg *config.Config) error {
+	store, err := NewStorage(cfg)
+	if err != nil {
+		return err
+	}
+	bindings, err := store.Bindings()
+	if err != nil {
+		return err
+	}
+	for username, id := range bindings {
+		cfg.Bind(id, username)
+	}
+	return nil
+}
+
+// Import copies polls with their journals from a directory of YAML files
+// to the storage of the config. Polls already in the storage are skipped.
+func Import(cfg *config.Config, dir string) ([]string, error) {
+	from := yamlStorage{dir: dir}
+	to, err := NewStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	names, err := from.List()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, name := range names {
+		data, err := from.Load(name)
+		if err != nil {
+			return res, err
+		}
+		events, err := from.Events(name)
+		if err != nil {
+			return res, err
+		}
+		err = to.Create(name)
+		if errors.Is(err, ErrExists) {
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		version, _ := diskVersion(data)
+		if err := to.Save(name, version, data, events); err != nil {
+			return res, err
+		}
+		res = append(res, name)
+	}
+	return res, nil
+}
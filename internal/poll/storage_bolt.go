@@ -0,0 +1,196 @@
+package poll
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pollsBucket    = []byte("polls")
+	journalsBucket = []byte("journals")
+	sessionsBucket = []byte("sessions")
+	bindingsBucket = []byte("bindings")
+)
+
+// boltStorage keeps everything in a single bolt database. Polls are stored
+// encoded the same way as in YAML files, every poll has its own journal
+// bucket keyed by event sequence numbers.
+//
+// The database is opened for every operation, so the bot and mitkactl
+// can use it at the same time: bolt allows a single process at once.
+type boltStorage struct {
+	path string
+}
+
+func (s boltStorage) update(f func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(s.path, 0666, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{pollsBucket, journalsBucket, sessionsBucket, bindingsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return f(tx)
+	})
+	if err2 := db.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (s boltStorage) view(f func(tx *bolt.Tx) error) error {
+	// update creates missing buckets, so a fresh database can be read.
+	return s.update(f)
+}
+
+func (s boltStorage) Create(name string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pollsBucket)
+		if b.Get([]byte(name)) != nil {
+			return fmt.Errorf("%v: %w", name, ErrExists)
+		}
+		return b.Put([]byte(name), []byte{})
+	})
+}
+
+func (s boltStorage) Remove(name string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(journalsBucket).DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return tx.Bucket(pollsBucket).Delete([]byte(name))
+	})
+}
+
+func (s boltStorage) List() (res []string, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(pollsBucket).ForEach(func(k, v []byte) error {
+			res = append(res, string(k))
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (s boltStorage) Load(name string) (res []byte, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		v := tx.Bucket(pollsBucket).Get([]byte(name))
+		if v == nil {
+			return fmt.Errorf("poll %v not found", name)
+		}
+		if len(v) == 0 {
+			return fmt.Errorf("poll %v is not saved yet", name)
+		}
+		res = append([]byte(nil), v...)
+		return nil
+	})
+	return res, err
+}
+
+func (s boltStorage) Save(name string, version uint64, data []byte, events []Event) error {
+	return s.update(func(tx *bolt.Tx) error {
+		polls := tx.Bucket(pollsBucket)
+		if v, ok := diskVersion(polls.Get([]byte(name))); ok && v != version {
+			return ErrConflict
+		}
+		if len(events) > 0 {
+			j, err := tx.Bucket(journalsBucket).CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				seq, err := j.NextSequence()
+				if err != nil {
+					return err
+				}
+				v, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				key := make([]byte, 8)
+				binary.BigEndian.PutUint64(key, seq)
+				if err := j.Put(key, v); err != nil {
+					return err
+				}
+			}
+		}
+		return polls.Put([]byte(name), data)
+	})
+}
+
+func (s boltStorage) Events(name string) (res []Event, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		j := tx.Bucket(journalsBucket).Bucket([]byte(name))
+		if j == nil {
+			return nil
+		}
+		return j.ForEach(func(k, v []byte) error {
+			var e Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("journal event %v: %w", binary.BigEndian.Uint64(k), err)
+			}
+			res = append(res, e)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (s boltStorage) Session(key string) (res []byte, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(sessionsBucket).Get([]byte(key)); v != nil {
+			res = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s boltStorage) SaveSession(key string, data []byte) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if data == nil {
+			return tx.Bucket(sessionsBucket).Delete([]byte(key))
+		}
+		return tx.Bucket(sessionsBucket).Put([]byte(key), data)
+	})
+}
+
+func (s boltStorage) Sessions() (map[string][]byte, error) {
+	res := make(map[string][]byte)
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			res[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (s boltStorage) Bindings() (map[string]int64, error) {
+	res := make(map[string]int64)
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bindingsBucket).ForEach(func(k, v []byte) error {
+			if len(v) != 8 {
+				return fmt.Errorf("bad binding of %s", k)
+			}
+			res[string(k)] = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (s boltStorage) SaveBinding(username string, id int64) error {
+	return s.update(func(tx *bolt.Tx) error {
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(id))
+		return tx.Bucket(bindingsBucket).Put([]byte(username), v)
+	})
+}
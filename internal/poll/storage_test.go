@@ -0,0 +1,41 @@
+package poll
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateExists(t *testing.T) {
+	dir := t.TempDir()
+	for _, s := range []Storage{yamlStorage{dir: dir}, boltStorage{path: filepath.Join(dir, "polls.db")}} {
+		if err := s.Create("p"); err != nil {
+			t.Fatalf("%T: %v", s, err)
+		}
+		if err := s.Create("p"); !errors.Is(err, ErrExists) {
+			t.Errorf("%T: second create = %v, want ErrExists", s, err)
+		}
+	}
+}
+
+func TestImportSkipsExisting(t *testing.T) {
+	from := testConfig(t)
+	p := testPoll(t, from, "p")
+	mustVote(t, p, "alice", "a", 5)
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	to := testConfig(t)
+	to.Storage = StorageBolt
+	to.StoragePath = filepath.Join(t.TempDir(), "polls.db")
+	for i, want := range []int{1, 0} {
+		names, err := Import(to, from.StoragePath)
+		if err != nil {
+			t.Fatalf("import %v: %v", i+1, err)
+		}
+		if len(names) != want {
+			t.Errorf("import %v = %v, want %v polls", i+1, names, want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package poll
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlStorage keeps a poll in <dir>/<name>.yml with a backup of the previous
+// version next to it, and its journal in <dir>/<name>.journal as JSON lines.
+// Sessions are kept in <dir>/sessions, member bindings in <dir>/members.json.
+type yamlStorage struct {
+	dir string
+}
+
+func (s yamlStorage) pollFile(name string) string {
+	return filepath.Join(s.dir, name+".yml")
+}
+
+func (s yamlStorage) journalFile(name string) string {
+	return filepath.Join(s.dir, name+".journal")
+}
+
+func (s yamlStorage) sessionDir() string {
+	return filepath.Join(s.dir, "sessions")
+}
+
+func (s yamlStorage) bindingFile() string {
+	return filepath.Join(s.dir, "members.json")
+}
+
+func backupFile(filename string) string {
+	return filename + ".bak"
+}
+
+func (s yamlStorage) Create(name string) error {
+	f, err := os.OpenFile(s.pollFile(name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return fmt.Errorf("%v: %w", name, ErrExists)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s yamlStorage) Remove(name string) error {
+	filename := s.pollFile(name)
+	for _, f := range []string{backupFile(filename), filename + ".lock", s.journalFile(name)} {
+		os.Remove(f)
+	}
+	return os.Remove(filename)
+}
+
+func (s yamlStorage) List() ([]string, error) {
+	files, err := filepath.Glob(s.pollFile("*"))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(files))
+	for i, f := range files {
+		res[i] = strings.TrimSuffix(filepath.Base(f), ".yml")
+	}
+	return res, nil
+}
+
+// readFile reads a poll file. An empty or undecodable file is reported as corrupt.
+func readFile(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("%v is corrupt: empty file", filename)
+	}
+	var v map[string]interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%v is corrupt: %w", filename, err)
+	}
+	return data, nil
+}
+
+// Load recovers a corrupt poll file from its backup, the state is rebuilt
+// from the journal anyway.
+func (s yamlStorage) Load(name string) ([]byte, error) {
+	filename := s.pollFile(name)
+	data, err := readFile(filename)
+	if err == nil || os.IsNotExist(err) {
+		return data, err
+	}
+	data, err2 := readFile(backupFile(filename))
+	if err2 != nil {
+		return nil, fmt.Errorf("%w, backup: %v", err, err2)
+	}
+	return data, nil
+}
+
+// diskVersion returns the version of an encoded poll, false if it can't be decoded.
+func diskVersion(data []byte) (uint64, bool) {
+	var v struct {
+		Version uint64 `yaml:"version"`
+	}
+	if len(bytes.TrimSpace(data)) == 0 || yaml.Unmarshal(data, &v) != nil {
+		return 0, false
+	}
+	return v.Version, true
+}
+
+// writeFile replaces the file atomically: data goes to a temporary file
+// first, which is renamed over the old one once it is synced.
+func writeFile(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err2 := d.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Save writes the journal and the poll file under a file lock shared by the
+// bot and mitkactl. The previous file is kept as a backup.
+func (s yamlStorage) Save(name string, version uint64, data []byte, events []Event) error {
+	filename := s.pollFile(name)
+	unlock, err := lockFile(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	old, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	v, ok := diskVersion(old)
+	if ok && v != version {
+		return ErrConflict
+	}
+
+	// the journal is cut back if the poll is not written, Poll.Save keeps
+	// the events and appends them again on the next try.
+	size, err := s.journalSize(name)
+	if err != nil {
+		return err
+	}
+	err = s.appendEvents(name, events)
+	if err == nil && ok {
+		err = writeFile(backupFile(filename), old)
+	}
+	if err == nil {
+		err = writeFile(filename, data)
+	}
+	if err != nil && len(events) > 0 {
+		if err2 := os.Truncate(s.journalFile(name), size); err2 != nil && !os.IsNotExist(err2) {
+			return fmt.Errorf("%w, journal not restored: %v", err, err2)
+		}
+	}
+	return err
+}
+
+func (s yamlStorage) journalSize(name string) (int64, error) {
+	fi, err := os.Stat(s.journalFile(name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s yamlStorage) appendEvents(name string, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(s.journalFile(name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		if err = enc.Encode(e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (s yamlStorage) Events(name string) ([]Event, error) {
+	f, err := os.Open(s.journalFile(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []Event
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<24)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("journal line %v: %w", len(res)+1, err)
+		}
+		res = append(res, e)
+	}
+	return res, sc.Err()
+}
+
+func (s yamlStorage) sessionFile(key string) string {
+	return filepath.Join(s.sessionDir(), key)
+}
+
+func (s yamlStorage) Session(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.sessionFile(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s yamlStorage) SaveSession(key string, data []byte) error {
+	if data == nil {
+		err := os.Remove(s.sessionFile(key))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(s.sessionDir(), 0777); err != nil {
+		return err
+	}
+	return writeFile(s.sessionFile(key), data)
+}
+
+func (s yamlStorage) Sessions() (map[string][]byte, error) {
+	files, err := os.ReadDir(s.sessionDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string][]byte, len(files))
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), ".tmp") {
+			continue
+		}
+		data, err := os.ReadFile(s.sessionFile(f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res[f.Name()] = data
+	}
+	return res, nil
+}
+
+func (s yamlStorage) Bindings() (map[string]int64, error) {
+	data, err := os.ReadFile(s.bindingFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]int64
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("%v: %w", s.bindingFile(), err)
+	}
+	return res, nil
+}
+
+func (s yamlStorage) SaveBinding(username string, id int64) error {
+	bindings, err := s.Bindings()
+	if err != nil {
+		return err
+	}
+	if bindings == nil {
+		bindings = make(map[string]int64)
+	}
+	bindings[username] = id
+	data, err := json.MarshalIndent(bindings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.bindingFile(), data)
+}
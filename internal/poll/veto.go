@@ -0,0 +1,106 @@
+package poll
+
+import (
+	"fmt"
+	"log"
+)
+
+func (s State) vetoed(id string) bool {
+	for _, v := range s.Vetoes {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// CanVeto reports whether name has a veto left. Instant runoff counts
+// preferences only, so ranked polls have no vetoes.
+func (p *Poll) CanVeto(name string) bool {
+	s := p.State[name]
+	return p.Phase == PhaseVoting && p.Method != MethodRanked && !s.Disabled &&
+		uint(len(s.Vetoes)) < p.Rules.Vetoes && len(p.GetViewToVeto(name)) > 0
+}
+
+func (p *Poll) CanUnveto(name string) bool {
+	return p.Phase == PhaseVoting && len(p.State[name].Vetoes) > 0
+}
+
+// GetViewToVeto returns variants name may veto: not their own, not voted
+// for and not vetoed yet.
+func (p *Poll) GetViewToVeto(name string) []View {
+	var res []View
+	for _, v := range p.GetView(name) {
+		if v.Count == 0 && !p.State[name].vetoed(v.ID) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// GetVetoes returns variants name has vetoed.
+func (p *Poll) GetVetoes(name string) []View {
+	var res []View
+	for _, v := range p.GetView(name) {
+		if p.State[name].vetoed(v.ID) {
+			v.Count = -int(p.Rules.VetoPoints)
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// VetoFor vetoes the variant on behalf of member, name is either member or their proxy.
+func (p *Poll) VetoFor(name, member, id string) error {
+	if !p.canActFor(name, member) {
+		return fmt.Errorf("%v is not a proxy of %v: %w", name, member, ErrForbidden)
+	}
+	if !p.CanVeto(member) {
+		return fmt.Errorf("you cant veto: %w", ErrForbidden)
+	}
+	return p.do(Event{User: name, Action: ActionVeto, Member: by(name, member), Variant: id})
+}
+
+// UnvetoFor takes the veto back on behalf of member.
+func (p *Poll) UnvetoFor(name, member, id string) error {
+	if !p.canActFor(name, member) {
+		return fmt.Errorf("%v is not a proxy of %v: %w", name, member, ErrForbidden)
+	}
+	return p.do(Event{User: name, Action: ActionUnveto, Member: by(name, member), Variant: id})
+}
+
+func (p *Poll) veto(name, id string) error {
+	var found bool
+	for _, v := range p.GetViewToVeto(name) {
+		found = found || v.ID == id
+	}
+	s := p.State[name]
+	switch {
+	case found:
+	case !p.replaying:
+		return fmt.Errorf("cant veto variant %v: %w", id, ErrForbidden)
+	case s.vetoed(id):
+		return nil
+	default:
+		log.Printf("WARN: poll %v: veto of %v on %v breaks the current rules", p.name, name, id)
+	}
+	s.Vetoes = append(append([]string(nil), s.Vetoes...), id)
+	p.State[name] = s
+	return nil
+}
+
+func (p *Poll) unveto(name, id string) error {
+	s := p.State[name]
+	if !s.vetoed(id) {
+		return fmt.Errorf("variant %v is not vetoed", id)
+	}
+	var n []string
+	for _, v := range s.Vetoes {
+		if v != id {
+			n = append(n, v)
+		}
+	}
+	s.Vetoes = n
+	p.State[name] = s
+	return nil
+}